@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"dev/tasks"
+)
+
+// Acceptance checks are task notes that must pass before the task can be completed:
+//
+//	accept: go test ./pkg/...        a shell command that must exit with 0
+//	accept: exists path/to/file      a file or directory that must exist
+//	accept: grep <regexp> <path>     a file, or any file of a directory, that must match
+//	accept: !grep <regexp> <path>    a file, or every file of a directory, that must not match
+const AcceptPrefix = "accept:"
+
+// AcceptTimeout bounds one acceptance command.
+const AcceptTimeout = 10 * time.Minute
+
+// MaxCheckOutputChars bounds the output of a failed check given back to the model.
+const MaxCheckOutputChars = 4000
+
+type Check struct {
+	Kind    string // run, exists, grep or !grep
+	Command string
+	Pattern string
+	Path    string
+}
+
+func (c Check) String() string {
+	switch c.Kind {
+	case "run":
+		return c.Command
+	case "exists":
+		return "exists " + c.Path
+	}
+	return fmt.Sprintf("%s %s %s", c.Kind, c.Pattern, c.Path)
+}
+
+// ParseCheck parses the acceptance check of a task note.
+func ParseCheck(note string) (Check, bool, error) {
+	if !strings.HasPrefix(note, AcceptPrefix) {
+		return Check{}, false, nil
+	}
+	spec := strings.TrimSpace(strings.TrimPrefix(note, AcceptPrefix))
+	fields := strings.Fields(spec)
+	if len(fields) == 0 {
+		return Check{}, true, fmt.Errorf("empty acceptance check")
+	}
+	switch fields[0] {
+	case "exists":
+		if len(fields) != 2 {
+			return Check{}, true, fmt.Errorf("%q: expected exists <path>", spec)
+		}
+		return Check{Kind: "exists", Path: fields[1]}, true, nil
+	case "grep", "!grep":
+		if len(fields) < 3 {
+			return Check{}, true, fmt.Errorf("%q: expected %s <regexp> <path>", spec, fields[0])
+		}
+		// The pattern may contain spaces, the path is the last field.
+		pattern := strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(spec, fields[0]), fields[len(fields)-1]))
+		if _, err := regexp.Compile(pattern); err != nil {
+			return Check{}, true, fmt.Errorf("%q: %w", spec, err)
+		}
+		return Check{Kind: fields[0], Pattern: pattern, Path: fields[len(fields)-1]}, true, nil
+	}
+	return Check{Kind: "run", Command: spec}, true, nil
+}
+
+// TaskChecks returns the acceptance checks of a task.
+func TaskChecks(task *tasks.Task) ([]Check, error) {
+	var checks []Check
+	for _, note := range task.Notes {
+		check, ok, err := ParseCheck(note)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			checks = append(checks, check)
+		}
+	}
+	return checks, nil
+}
+
+// Run runs the check in the working directory. The error describes why it failed.
+func (c Check) Run(ctx context.Context) error {
+	switch c.Kind {
+	case "run":
+		ctx, cancel := context.WithTimeout(ctx, AcceptTimeout)
+		defer cancel()
+		start := time.Now()
+		command := exec.CommandContext(ctx, "sh", "-c", c.Command)
+		command.Dir = workingDirectory
+		output, err := command.CombinedOutput()
+		recordCommand("accept", c.Command, err == nil, string(output), err, start)
+		if err != nil {
+			return fmt.Errorf("%s: %w\n%s", c.Command, err, lastChars(string(output), MaxCheckOutputChars))
+		}
+		return nil
+	case "exists":
+		if _, err := os.Stat(Path(c.Path)); err != nil {
+			return fmt.Errorf("%s does not exist", c.Path)
+		}
+		return nil
+	}
+
+	re := regexp.MustCompile(c.Pattern)
+	var matches []string
+	err := filepath.WalkDir(Path(c.Path), func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != Path(c.Path) && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if isAgentFile(path) {
+			return nil
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		for i, line := range strings.Split(string(content), "\n") {
+			if re.MatchString(line) {
+				rel, _ := filepath.Rel(workingDirectory, path)
+				matches = append(matches, fmt.Sprintf("%s:%d: %s", rel, i+1, strings.TrimSpace(line)))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", c, err)
+	}
+	if c.Kind == "grep" && len(matches) == 0 {
+		return fmt.Errorf("%s: no match", c)
+	}
+	if c.Kind == "!grep" && len(matches) > 0 {
+		return fmt.Errorf("%s: unexpected matches\n%s", c, lastChars(strings.Join(matches, "\n"), MaxCheckOutputChars))
+	}
+	return nil
+}
+
+// RunChecks runs every check and returns the failures, empty when all of them pass.
+func RunChecks(ctx context.Context, checks []Check) string {
+	var failures []string
+	for _, check := range checks {
+		if err := check.Run(ctx); err != nil {
+			failures = append(failures, fmt.Sprintf("- %s", err))
+		}
+	}
+	return strings.Join(failures, "\n")
+}
+
+// isAgentFile reports whether path is one of the files of the agent itself, which
+// directory checks ignore since they quote the checks.
+func isAgentFile(path string) bool {
+	switch path {
+	case TasksPath(), tasks.MetaPath(TasksPath()), Path("INPUT.md"), QuestionsPath():
+		return true
+	}
+	return false
+}
+
+func lastChars(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return "[output truncated]\n" + s[len(s)-n:]
+}
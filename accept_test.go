@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestParseCheck(t *testing.T) {
+	tests := []struct {
+		note  string
+		check Check
+		ok    bool
+		err   bool
+	}{
+		{"Listen on :8080.", Check{}, false, false},
+		{"accept: go test ./pkg/...", Check{Kind: "run", Command: "go test ./pkg/..."}, true, false},
+		{"accept: exists cmd/main.go", Check{Kind: "exists", Path: "cmd/main.go"}, true, false},
+		{"accept: grep func Serve\\( server.go", Check{Kind: "grep", Pattern: "func Serve\\(", Path: "server.go"}, true, false},
+		{"accept: !grep TODO .", Check{Kind: "!grep", Pattern: "TODO", Path: "."}, true, false},
+		{"accept: grep ( server.go", Check{}, true, true},
+		{"accept: exists", Check{}, true, true},
+		{"accept:", Check{}, true, true},
+	}
+	for _, test := range tests {
+		check, ok, err := ParseCheck(test.note)
+		if ok != test.ok || (err != nil) != test.err || (err == nil && check != test.check) {
+			t.Errorf("ParseCheck(%q) = %+v, %v, %v", test.note, check, ok, err)
+		}
+	}
+}
+
+func TestCompleteTaskRunsAcceptanceChecks(t *testing.T) {
+	workingDirectory = t.TempDir()
+	session = NewSession()
+	defer func() { session = nil }()
+
+	tool := func(name string, arguments map[string]any) string {
+		return ToolCall(context.Background(), openai.ToolCall{Function: call(name, arguments)})
+	}
+	tool("add_task", map[string]any{"title": "Server", "notes": []string{
+		"accept: exists server.go",
+		"accept: grep ^func Serve server.go",
+		"accept: !grep TODO .",
+		"accept: test -s server.go",
+	}})
+
+	result := tool("complete_task", map[string]any{"id": 1})
+	if !strings.Contains(result, "not done") || !strings.Contains(result, "server.go does not exist") {
+		t.Errorf("Expected the missing file to fail the checks, got %q", result)
+	}
+	os.WriteFile(filepath.Join(workingDirectory, "server.go"), []byte("package main\n\nfunc Serve() {} // TODO\n"), 0644)
+	result = tool("complete_task", map[string]any{"id": 1})
+	if !strings.Contains(result, "server.go:3: func Serve() {} // TODO") || strings.Contains(result, "does not exist") {
+		t.Errorf("Expected only the TODO to fail the checks, got %q", result)
+	}
+	doc, _ := LoadTasks()
+	if doc.Find(1).State.Open() != true || !strings.Contains(doc.Meta(1).LastError, "unexpected matches") {
+		t.Errorf("Expected the task to stay open with the failure, got %+v", doc.Meta(1))
+	}
+
+	os.WriteFile(filepath.Join(workingDirectory, "server.go"), []byte("package main\n\nfunc Serve() {}\n"), 0644)
+	if result := tool("complete_task", map[string]any{"id": 1}); result != "Completed task #1" {
+		t.Errorf("Expected the task to complete, got %q", result)
+	}
+}
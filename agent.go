@@ -4,85 +4,193 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"strings"
+	"slices"
 
 	"github.com/sashabaranov/go-openai"
 )
 
-const (
-	MODEL = "openai/gpt-4.1-mini"
-	// MODEL = "google/gemini-2.5-flash-preview"
-	// MODEL = "anthropic/claude-3.7-sonnet"
-)
-
 var messages []openai.ChatCompletionMessage
 var workingDirectory string
 
-func handleChatCompletion(msg openai.ChatCompletionMessage) string {
-	pendingMessages := []openai.ChatCompletionMessage{
-		msg,
-	}
+// verbose prints the whole conversation before every model request, and the tool results.
+var verbose bool
 
-	for len(pendingMessages) > 0 {
-		messages = append(messages, pendingMessages...)
-		pendingMessages = nil
+// handleChatCompletion sends msg and keeps answering tool calls until the model stops
+// calling tools. Errors are either a *LoopError or come from createChatCompletion.
+func handleChatCompletion(ctx context.Context, model ModelConfig, msg openai.ChatCompletionMessage) (string, error) {
+	messages = append(messages, msg)
+	saveSession()
+	return continueChatCompletion(ctx, model)
+}
 
-		log.Println("\n\n\n\n\n#########################################################################\nMESSAGES")
-		for _, message := range messages {
-			fmt.Println("--------------------------------")
-			content := message.Content
-			if content == "" && len(message.ToolCalls) > 0 {
-				content = message.ToolCalls[0].Function.Name
-			}
-			role := message.Role
-			if role == "tool" {
-				role = message.ToolCallID
-			}
-			fmt.Printf("%s: %s\n", role, content)
+// continueChatCompletion runs the conversation in messages until the model stops calling
+// tools. It also picks up a conversation restored from a session: tool calls without a
+// result are run first, and a finished conversation returns its last answer.
+func continueChatCompletion(ctx context.Context, model ModelConfig) (string, error) {
+	for {
+		if last := messages[len(messages)-1]; last.Role == openai.ChatMessageRoleAssistant && len(last.ToolCalls) == 0 {
+			log.Printf("Finished loop, returning last message: %s", last.Content)
+			return last.Content, nil
 		}
-		response, err := client.CreateChatCompletion(
-			context.Background(),
+		if finished := runPendingToolCalls(ctx); finished {
+			return "Finished all tasks", nil
+		}
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+
+		compactMessages(ctx, model)
+
+		if verbose {
+			printMessages()
+		}
+		response, err := createChatCompletion(
+			ctx,
 			openai.ChatCompletionRequest{
-				Model:    MODEL,
-				Messages: messages,
-				Tools:    GetTools(),
+				Model:       model.Model,
+				Messages:    messages,
+				Tools:       GetTools(),
+				Temperature: requestTemperature(model.Temperature),
+				MaxTokens:   model.MaxTokens,
 			},
 		)
 		if err != nil {
-			var contextLength int
-			for _, message := range messages {
-				contextLength += len(message.Content)
-			}
-			log.Printf("Context length: %d", contextLength)
-			panic(err)
+			log.Printf("Context length: ~%d tokens", EstimateTokens(model.Model, messages))
+			return "", err
 		}
 
 		if len(response.Choices) == 0 || (response.Choices[0].Message.Content == "" && response.Choices[0].Message.ToolCalls == nil) {
 			log.Printf("No response from assistant: %+v\n%+v\n", response, messages)
-			return "no_response"
+			return "no_response", nil
 		}
 
+		// A turn that trips the loop detector is dropped, its tool calls are not run.
+		if err := loop.ObserveTurn(response.Choices[0].Message); err != nil {
+			return "", err
+		}
 		messages = append(messages, response.Choices[0].Message)
+		saveSession()
 		if response.Choices[0].Message.Content != "" {
 			log.Printf("Assistant: %s", response.Choices[0].Message.Content)
 		}
+	}
+}
+
+// runPendingToolCalls answers the tool calls of the last assistant message that have
+// no result in messages yet. It reports whether one of them was the finished tool.
+// Results are not recorded once ctx is canceled, so a resumed run calls those tools again.
+func runPendingToolCalls(ctx context.Context) bool {
+	i := len(messages) - 1
+	answered := map[string]bool{}
+	for ; i >= 0 && messages[i].Role == openai.ChatMessageRoleTool; i-- {
+		answered[messages[i].ToolCallID] = true
+	}
+	if i < 0 || messages[i].Role != openai.ChatMessageRoleAssistant {
+		return false
+	}
 
-		toolCalls := response.Choices[0].Message.ToolCalls
-		for _, toolCall := range toolCalls {
-			if toolCall.Function.Name == "finished" {
-				return "Finished all tasks"
+	var pending []openai.ToolCall
+	finished := false
+	for _, toolCall := range messages[i].ToolCalls {
+		if answered[toolCall.ID] {
+			continue
+		}
+		if toolCall.Function.Name == "finished" {
+			finished = true
+			break
+		}
+		pending = append(pending, toolCall)
+	}
+	runToolCalls(ctx, pending, func(result openai.ChatCompletionMessage) {
+		if ctx.Err() != nil {
+			return
+		}
+		messages = append(messages, result)
+		saveSession()
+	})
+	return finished
+}
+
+// runToolCalls runs the tool calls of one assistant turn on a bounded pool of workers and
+// emits the results in the original order. Read-only tools run concurrently, write tools
+// run one after the other for each path, together with the reads of a path being written.
+// Reads of the whole tree or of a directory wait for every earlier write, and writes without
+// a path wait for every earlier call.
+func runToolCalls(ctx context.Context, toolCalls []openai.ToolCall, emit func(openai.ChatCompletionMessage)) {
+	workers := config.MaxParallelTools
+	if workers <= 0 {
+		workers = 1
+	}
+	semaphore := make(chan struct{}, workers)
+
+	written := map[string]bool{}
+	for _, toolCall := range toolCalls {
+		if !IsReadOnlyTool(toolCall.Function.Name) {
+			written[toolPathKey(toolCall)] = true
+		}
+	}
+
+	// last holds, for each path, a channel closed when the previous call on it is done.
+	last := map[string]chan struct{}{}
+	// The channels of the earlier calls, of the earlier writes, and of the earlier calls
+	// on the whole tree.
+	var all, writes, treeReads, treeWrites []chan struct{}
+	results := make([]chan openai.ChatCompletionMessage, len(toolCalls))
+	for i, toolCall := range toolCalls {
+		results[i] = make(chan openai.ChatCompletionMessage, 1)
+
+		key := toolPathKey(toolCall)
+		done := make(chan struct{})
+		var wait []chan struct{}
+		switch write := !IsReadOnlyTool(toolCall.Function.Name); {
+		case isWebTool(toolCall.Function.Name):
+		case write && (key == "" || lintsPackage(toolCall.Function.Name)):
+			wait = slices.Clone(all)
+			writes = append(writes, done)
+			treeWrites = append(treeWrites, done)
+		case write:
+			wait = slices.Concat(treeReads, treeWrites)
+			if last[key] != nil {
+				wait = append(wait, last[key])
+			}
+			last[key] = done
+			writes = append(writes, done)
+		case readsTree(toolCall.Function.Name, key):
+			wait = slices.Clone(writes)
+			treeReads = append(treeReads, done)
+		default:
+			wait = slices.Clone(treeWrites)
+			if written[key] {
+				if last[key] != nil {
+					wait = append(wait, last[key])
+				}
+				last[key] = done
 			}
-			pendingMessages = append(pendingMessages, handleToolCall(toolCall))
 		}
+		all = append(all, done)
+
+		go func(toolCall openai.ToolCall, result chan<- openai.ChatCompletionMessage) {
+			for _, w := range wait {
+				<-w
+			}
+			semaphore <- struct{}{}
+			result <- handleToolCall(ctx, toolCall)
+			<-semaphore
+			close(done)
+		}(toolCall, results[i])
 	}
 
-	log.Printf("Finished loop, returning last message: %s", messages[len(messages)-1].Content)
-	return messages[len(messages)-1].Content
+	for _, result := range results {
+		emit(<-result)
+	}
 }
 
-func handleToolCall(toolCall openai.ToolCall) openai.ChatCompletionMessage {
+func handleToolCall(ctx context.Context, toolCall openai.ToolCall) openai.ChatCompletionMessage {
 	log.Printf("[TOOL] %s %s", toolCall.Function.Name, toolCall.Function.Arguments)
-	res := ToolCall(toolCall)
+	res := ToolCall(ctx, toolCall)
+	if verbose {
+		log.Printf("[RESULT] %s %s", toolCall.Function.Name, res)
+	}
 	return openai.ChatCompletionMessage{
 		Role:       openai.ChatMessageRoleTool,
 		Content:    res,
@@ -90,57 +198,18 @@ func handleToolCall(toolCall openai.ToolCall) openai.ChatCompletionMessage {
 	}
 }
 
-func YesNoQuestion(question string) bool {
-	response, err := client.CreateChatCompletion(
-		context.Background(),
-		openai.ChatCompletionRequest{
-			Model: MODEL,
-			Messages: []openai.ChatCompletionMessage{
-				{
-					Role:    "system",
-					Content: `You must answer the question with a "yes" or "no" tool call.`,
-				},
-				{
-					Role:    "user",
-					Content: question,
-				},
-			},
-			Stream:      false,
-			Temperature: 0.7,
-			Tools: []openai.Tool{
-				{
-					Type: openai.ToolTypeFunction,
-					Function: &openai.FunctionDefinition{
-						Name:        "yes",
-						Description: "Answer affirmatively",
-					},
-				},
-				{
-					Type: openai.ToolTypeFunction,
-					Function: &openai.FunctionDefinition{
-						Name:        "no",
-						Description: "Answer negatively",
-					},
-				},
-			},
-		},
-	)
-	if err != nil {
-		log.Printf("Error in YesNoQuestion: %s", err)
-		return false
-	}
-	if len(response.Choices) == 0 || len(response.Choices[0].Message.ToolCalls) == 0 {
-		log.Printf("No response from assistant: %+v", response)
-		return false
-	}
-	if response.Choices[0].Message.ToolCalls[0].Function.Name == "yes" {
-		return true
-	}
-	if response.Choices[0].Message.ToolCalls[0].Function.Name == "no" {
-		return false
-	}
-	if strings.Contains(response.Choices[0].Message.Content, "yes") {
-		return true
+func printMessages() {
+	log.Println("\n\n\n\n\n#########################################################################\nMESSAGES")
+	for _, message := range messages {
+		fmt.Println("--------------------------------")
+		content := message.Content
+		if content == "" && len(message.ToolCalls) > 0 {
+			content = message.ToolCalls[0].Function.Name
+		}
+		role := message.Role
+		if role == "tool" {
+			role = message.ToolCallID
+		}
+		fmt.Printf("%s: %s\n", role, content)
 	}
-	return false
 }
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestRunToolCalls(t *testing.T) {
+	workingDirectory = t.TempDir()
+	config.MaxParallelTools = 3
+	defer func() { config = Config{} }()
+
+	for i := 0; i < 5; i++ {
+		if err := os.WriteFile(filepath.Join(workingDirectory, fmt.Sprintf("%d.txt", i)), []byte(fmt.Sprintf("file %d", i)), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+	}
+
+	var toolCalls []openai.ToolCall
+	for i := 0; i < 5; i++ {
+		toolCalls = append(toolCalls, openai.ToolCall{
+			ID:       fmt.Sprintf("read_%d", i),
+			Function: call("read_file", map[string]any{"path": fmt.Sprintf("%d.txt", i)}),
+		})
+	}
+	// A write followed by a read of the same path must see the written content.
+	toolCalls = append(toolCalls,
+		openai.ToolCall{ID: "write", Function: call("write_file", map[string]any{"path": "0.txt", "content": "rewritten"})},
+		openai.ToolCall{ID: "read_again", Function: call("read_file", map[string]any{"path": "0.txt"})},
+	)
+
+	var results []openai.ChatCompletionMessage
+	runToolCalls(context.Background(), toolCalls, func(result openai.ChatCompletionMessage) {
+		results = append(results, result)
+	})
+
+	if len(results) != len(toolCalls) {
+		t.Fatalf("Expected %d results, got %d", len(toolCalls), len(results))
+	}
+	for i, result := range results {
+		if result.ToolCallID != toolCalls[i].ID {
+			t.Errorf("Result %d: expected tool call %s, got %s", i, toolCalls[i].ID, result.ToolCallID)
+		}
+	}
+	for i := 0; i < 5; i++ {
+		if results[i].Content != fmt.Sprintf("file %d", i) {
+			t.Errorf("Result %d: unexpected content %q", i, results[i].Content)
+		}
+	}
+	if !strings.Contains(results[5].Content, "rewritten") || results[6].Content != "rewritten" {
+		t.Errorf("Expected the read after the write to see the new content, got %q", results[6].Content)
+	}
+
+	// Reads of the whole tree or of a directory see the earlier writes of the turn.
+	for i := 0; i < 20; i++ {
+		name := fmt.Sprintf("new_%d.txt", i)
+		toolCalls := []openai.ToolCall{
+			{ID: "write", Function: call("write_file", map[string]any{"path": name, "content": "needle"})},
+			{ID: "list", Function: call("list_directory", map[string]any{"path": ".", "depth": 1})},
+			{ID: "search", Function: call("search_text", map[string]any{"query": "needle"})},
+		}
+		var results []openai.ChatCompletionMessage
+		runToolCalls(context.Background(), toolCalls, func(result openai.ChatCompletionMessage) {
+			results = append(results, result)
+		})
+		if !strings.Contains(results[1].Content, name) || !strings.Contains(results[2].Content, name) {
+			t.Fatalf("Expected the reads after the write to see %s, got %q and %q", name, results[1].Content, results[2].Content)
+		}
+	}
+
+	// A write that lints the package, here of notes.md, tidies the go.mod next to it
+	// before it is read.
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go is not installed")
+	}
+	os.WriteFile(filepath.Join(workingDirectory, "go.mod"), []byte("module notes\ngo 1.23\n"), 0644)
+	os.WriteFile(filepath.Join(workingDirectory, "notes.go"), []byte("package notes\n"), 0644)
+	toolCalls = []openai.ToolCall{
+		{ID: "write", Function: call("write_file", map[string]any{"path": "notes.md", "content": "# Notes\n"})},
+		{ID: "read", Function: call("read_file", map[string]any{"path": "go.mod"})},
+	}
+	results = nil
+	runToolCalls(context.Background(), toolCalls, func(result openai.ChatCompletionMessage) {
+		results = append(results, result)
+	})
+	if results[1].Content != "module notes\n\ngo 1.23\n" {
+		t.Errorf("Expected the read to wait for go mod tidy, got %q", results[1].Content)
+	}
+}
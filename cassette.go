@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// A Cassette holds the model requests and responses of a run, in order.
+// Recording a real run and replaying it gives a deterministic end-to-end test.
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+type Interaction struct {
+	Request  openai.ChatCompletionRequest  `json:"request"`
+	Response openai.ChatCompletionResponse `json:"response"`
+	// Error and StatusCode are set when the request failed.
+	Error      string `json:"error,omitempty"`
+	StatusCode int    `json:"status_code,omitempty"`
+}
+
+func LoadCassette(path string) (*Cassette, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cassette Cassette
+	if err := json.Unmarshal(content, &cassette); err != nil {
+		return nil, fmt.Errorf("parsing cassette %s: %w", path, err)
+	}
+	return &cassette, nil
+}
+
+func (c *Cassette) Save(path string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// RecordingProvider forwards requests to another provider and appends every
+// interaction to a cassette file.
+type RecordingProvider struct {
+	inner    Provider
+	path     string
+	mu       sync.Mutex
+	cassette Cassette
+}
+
+func NewRecordingProvider(inner Provider, path string) *RecordingProvider {
+	return &RecordingProvider{inner: inner, path: path}
+}
+
+func (p *RecordingProvider) CreateChatCompletion(ctx context.Context, request openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	response, err := p.inner.CreateChatCompletion(ctx, request)
+
+	interaction := Interaction{Request: request, Response: response}
+	if err != nil {
+		interaction.Error = err.Error()
+		var httpErr *HTTPError
+		if errors.As(err, &httpErr) {
+			interaction.StatusCode = httpErr.StatusCode
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.cassette.Interactions = append(p.cassette.Interactions, interaction)
+	// Saved after every call so an interrupted run still leaves a usable cassette.
+	if saveErr := p.cassette.Save(p.path); saveErr != nil {
+		return response, fmt.Errorf("saving cassette: %w", saveErr)
+	}
+	return response, err
+}
+
+func (p *RecordingProvider) Capabilities() Capabilities {
+	return p.inner.Capabilities()
+}
+
+// ReplayProvider serves the responses of a cassette in order, without any network.
+// It fails when the agent asks for a different model than the one recorded, which
+// means the run diverged from the recording.
+type ReplayProvider struct {
+	mu       sync.Mutex
+	cassette *Cassette
+	next     int
+	// Received holds the requests served so far, for tests to inspect.
+	Received []openai.ChatCompletionRequest
+}
+
+func NewReplayProvider(cassette *Cassette) *ReplayProvider {
+	return &ReplayProvider{cassette: cassette}
+}
+
+func (p *ReplayProvider) CreateChatCompletion(ctx context.Context, request openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.next >= len(p.cassette.Interactions) {
+		return openai.ChatCompletionResponse{}, fmt.Errorf("cassette exhausted after %d interactions", p.next)
+	}
+	interaction := p.cassette.Interactions[p.next]
+	if interaction.Request.Model != request.Model {
+		return openai.ChatCompletionResponse{}, fmt.Errorf("interaction %d: expected a request for model %q, got %q", p.next, interaction.Request.Model, request.Model)
+	}
+	p.next++
+	p.Received = append(p.Received, request)
+
+	if interaction.Error != "" {
+		err := errors.New(interaction.Error)
+		if interaction.StatusCode != 0 {
+			return interaction.Response, &HTTPError{StatusCode: interaction.StatusCode, Err: err}
+		}
+		return interaction.Response, err
+	}
+	return interaction.Response, nil
+}
+
+func (p *ReplayProvider) Capabilities() Capabilities {
+	return Capabilities{ToolChoice: true}
+}
+
+// Remaining returns the number of interactions not replayed yet.
+func (p *ReplayProvider) Remaining() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.cassette.Interactions) - p.next
+}
+
+// ServeHTTP makes the replay provider a local stand-in for an OpenAI-compatible
+// server, so a replay can go through the real HTTP client.
+func (p *ReplayProvider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var request openai.ChatCompletionRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	response, err := p.CreateChatCompletion(r.Context(), request)
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		var httpErr *HTTPError
+		if errors.As(err, &httpErr) {
+			statusCode = httpErr.StatusCode
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(statusCode)
+		json.NewEncoder(w).Encode(openai.ErrorResponse{Error: &openai.APIError{Message: err.Error(), Type: "replay_error"}})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// A Question is an ambiguity of INPUT.md the planner wants answered before planning.
+type Question struct {
+	Question   string `json:"question"`
+	Context    string `json:"context,omitempty"`
+	Assumption string `json:"assumption,omitempty"`
+	Answer     string `json:"answer,omitempty"`
+}
+
+// ErrAwaitingAnswers stops a run that is not interactive once the questions are written.
+var ErrAwaitingAnswers = errors.New("waiting for the answers in QUESTIONS.md")
+
+// interactive reports whether the answers can be asked on the terminal.
+var interactive = func() bool {
+	stat, err := os.Stdin.Stat()
+	return err == nil && stat.Mode()&os.ModeCharDevice != 0
+}
+
+var answers io.Reader = os.Stdin
+
+func QuestionsPath() string {
+	return filepath.Join(workingDirectory, "QUESTIONS.md")
+}
+
+// clarify asks the planner for the ambiguities of INPUT.md and gets them answered,
+// on the terminal or in QUESTIONS.md, before moving the run to planning. A resumed
+// run reads the answers written in QUESTIONS.md.
+func clarify(ctx context.Context) error {
+	if len(session.Questions) == 0 {
+		if _, err := converse(ctx, config.Models.Planner, openai.ChatCompletionMessage{
+			Role: openai.ChatMessageRoleUser,
+			Content: `
+				Open a file called INPUT.md and read the content, and look at the project as much as you need.
+				Before anything is planned, find what INPUT.md leaves open: ambiguities, missing details and
+				contradictions whose answer would change what gets built. Do not ask what the project already answers.
+				Submit them with submit_questions, with the assumption you would make without an answer,
+				or submit no questions when INPUT.md is clear.
+			`,
+		}); err != nil {
+			return err
+		}
+		if len(session.Questions) == 0 {
+			log.Printf("No questions about INPUT.md")
+			session.SetPhase(PhasePlan)
+			return nil
+		}
+	} else if _, err := os.Stat(QuestionsPath()); err == nil {
+		// The run was resumed after the questions were written.
+		if err := ReadAnswers(session.Questions); err != nil {
+			return fmt.Errorf("reading QUESTIONS.md: %w", err)
+		}
+		session.SetPhase(PhasePlan)
+		return nil
+	}
+
+	if err := WriteQuestions(session.Questions); err != nil {
+		return fmt.Errorf("writing QUESTIONS.md: %w", err)
+	}
+	if !interactive() {
+		return ErrAwaitingAnswers
+	}
+	if err := askAnswers(session.Questions, answers, os.Stdout); err != nil {
+		return err
+	}
+	if err := WriteQuestions(session.Questions); err != nil {
+		return fmt.Errorf("writing QUESTIONS.md: %w", err)
+	}
+	session.SetPhase(PhasePlan)
+	return nil
+}
+
+// askAnswers asks every question on the terminal, an empty answer keeps the assumption.
+func askAnswers(questions []Question, in io.Reader, out io.Writer) error {
+	scanner := bufio.NewScanner(in)
+	for i := range questions {
+		q := &questions[i]
+		fmt.Fprintf(out, "\n%d. %s\n", i+1, q.Question)
+		if q.Context != "" {
+			fmt.Fprintf(out, "   %s\n", q.Context)
+		}
+		if q.Assumption != "" {
+			fmt.Fprintf(out, "   [%s] ", q.Assumption)
+		}
+		fmt.Fprint(out, "> ")
+		if !scanner.Scan() {
+			return scanner.Err()
+		}
+		q.Answer = strings.TrimSpace(scanner.Text())
+	}
+	return nil
+}
+
+func WriteQuestions(questions []Question) error {
+	var b strings.Builder
+	b.WriteString("# Questions\n\n")
+	b.WriteString("Write the answers after \"Answer:\" and run `dev resume`. Questions left unanswered use the assumption.\n")
+	for i, q := range questions {
+		fmt.Fprintf(&b, "\n## %d. %s\n\n", i+1, q.Question)
+		if q.Context != "" {
+			fmt.Fprintf(&b, "%s\n\n", q.Context)
+		}
+		if q.Assumption != "" {
+			fmt.Fprintf(&b, "Assumption: %s\n\n", q.Assumption)
+		}
+		fmt.Fprintf(&b, "Answer: %s\n", q.Answer)
+	}
+	return writeFileAtomic(QuestionsPath(), []byte(b.String()), 0644)
+}
+
+var questionHeading = regexp.MustCompile(`(?m)^## (\d+)\. `)
+
+// ReadAnswers reads the answers of QUESTIONS.md into questions. An answer goes from
+// "Answer:" to the next question and may span several lines.
+func ReadAnswers(questions []Question) error {
+	content, err := os.ReadFile(QuestionsPath())
+	if err != nil {
+		return err
+	}
+	text := string(content)
+	headings := questionHeading.FindAllStringSubmatchIndex(text, -1)
+	for i, heading := range headings {
+		end := len(text)
+		if i+1 < len(headings) {
+			end = headings[i+1][0]
+		}
+		n, _ := strconv.Atoi(text[heading[2]:heading[3]])
+		if n < 1 || n > len(questions) {
+			continue
+		}
+		section := text[heading[1]:end]
+		if j := strings.Index(section, "\nAnswer:"); j >= 0 {
+			questions[n-1].Answer = strings.TrimSpace(section[j+len("\nAnswer:"):])
+		}
+	}
+	return nil
+}
+
+// clarifications renders the answered questions for the planner.
+func clarifications(questions []Question) string {
+	if len(questions) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("Clarifications of INPUT.md:\n")
+	for _, q := range questions {
+		switch {
+		case q.Answer != "":
+			fmt.Fprintf(&b, "- %s\n  Answer: %s\n", q.Question, q.Answer)
+		case q.Assumption != "":
+			fmt.Fprintf(&b, "- %s\n  No answer, assume: %s\n", q.Question, q.Assumption)
+		default:
+			fmt.Fprintf(&b, "- %s\n  No answer, use your judgement.\n", q.Question)
+		}
+	}
+	return b.String()
+}
+
+// SubmitQuestions is the submit_questions tool.
+func SubmitQuestions(questions []Question) string {
+	if session.Phase != PhaseClarify {
+		return "Error: questions can only be submitted before planning"
+	}
+	var kept []Question
+	for _, q := range questions {
+		if strings.TrimSpace(q.Question) != "" {
+			q.Answer = ""
+			kept = append(kept, q)
+		}
+	}
+	session.Questions = kept
+	if len(kept) == 0 {
+		return "No questions, INPUT.md is clear"
+	}
+	return fmt.Sprintf("Recorded %d questions, they will be asked before planning", len(kept))
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestClarify(t *testing.T) {
+	const model = "test-model"
+	questions := []map[string]any{
+		{"question": "Which database?", "context": "INPUT.md says to store the users.", "assumption": "SQLite"},
+		{"question": "Which format?", "assumption": "JSON"},
+	}
+	cassette := &Cassette{Interactions: []Interaction{
+		toolCallInteraction(model, call("submit_questions", map[string]any{"questions": questions})),
+		textInteraction(model, "Asked."),
+		textInteraction(model, "Planned."),
+		verdictInteraction(model, "yes"),
+	}}
+	replay := setupRun(t, "Store the users.\n", cassette)
+	config.Clarify = true
+	session = NewSession()
+	interactive = func() bool { return false }
+	t.Cleanup(func() { interactive = nil })
+
+	ctx := context.Background()
+	if err := run(ctx); !errors.Is(err, ErrAwaitingAnswers) {
+		t.Fatalf("Expected the run to wait for the answers, got %v", err)
+	}
+	content, _ := os.ReadFile(QuestionsPath())
+	if !strings.Contains(string(content), "## 1. Which database?\n\nINPUT.md says to store the users.\n\nAssumption: SQLite\n\nAnswer: \n") {
+		t.Fatalf("Unexpected QUESTIONS.md:\n%s", content)
+	}
+
+	answered := strings.Replace(string(content), "Answer: \n", "Answer: Postgres,\nversion 16\n", 1)
+	os.WriteFile(QuestionsPath(), []byte(answered), 0644)
+	if err := run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if replay.Remaining() != 0 {
+		t.Errorf("Expected the whole cassette to be replayed, %d interactions left", replay.Remaining())
+	}
+	prompt := replay.Received[2].Messages[1].Content
+	for _, want := range []string{"- Which database?\n  Answer: Postgres,\nversion 16\n", "- Which format?\n  No answer, assume: JSON\n"} {
+		if !strings.Contains(prompt, want) {
+			t.Errorf("Expected the planner to be given %q, got:\n%s", want, prompt)
+		}
+	}
+}
+
+func TestAskAnswers(t *testing.T) {
+	questions := []Question{{Question: "Which database?", Assumption: "SQLite"}, {Question: "Which format?"}}
+	var out strings.Builder
+	if err := askAnswers(questions, strings.NewReader("Postgres\n\n"), &out); err != nil {
+		t.Fatal(err)
+	}
+	if questions[0].Answer != "Postgres" || questions[1].Answer != "" {
+		t.Errorf("Unexpected answers %+v", questions)
+	}
+	if !strings.Contains(out.String(), "1. Which database?\n   [SQLite] > ") {
+		t.Errorf("Unexpected prompt:\n%s", out.String())
+	}
+}
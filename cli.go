@@ -0,0 +1,478 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// version is set when building a release: go build -ldflags "-X main.version=v1.2.3".
+var version string
+
+// phases are the phases of a run that can run on their own with `dev run -phase`.
+var phases = []string{PhaseClarify, PhasePlan, PhaseTask, PhaseReview}
+
+// runFlags are the flags of the commands that talk to a model.
+type runFlags struct {
+	*flag.FlagSet
+	config        string
+	provider      string
+	baseURL       string
+	model         string
+	plannerModel  string
+	executorModel string
+	judgeModel    string
+	record        string
+	replay        string
+	maxSteps      int
+	dryRun        bool
+	verbose       bool
+	quiet         bool
+
+	// Only for run and plan.
+	clarify bool
+	// Only for run.
+	task  int
+	phase string
+}
+
+func newRunFlags(command string) *runFlags {
+	f := &runFlags{FlagSet: flag.NewFlagSet(command, flag.ExitOnError)}
+	f.Usage = func() {
+		fmt.Fprintf(f.Output(), "Usage: dev %s [flags] [working directory]\n\nFlags:\n", command)
+		f.PrintDefaults()
+	}
+	f.StringVar(&f.config, "config", "", "Path to the config file (default: <working directory>/"+ConfigFile+")")
+	f.StringVar(&f.provider, "provider", "", "LLM provider: openrouter, openai or anthropic")
+	f.StringVar(&f.baseURL, "base-url", "", "Base URL of the provider API")
+	f.StringVar(&f.model, "model", "", "Model used for every role")
+	f.StringVar(&f.plannerModel, "planner-model", "", "Model used to plan the tasks")
+	f.StringVar(&f.executorModel, "executor-model", "", "Model used to execute the tasks")
+	f.StringVar(&f.judgeModel, "judge-model", "", "Model used to judge the results")
+	f.StringVar(&f.record, "record", "", "Record every model request and response to this cassette file")
+	f.StringVar(&f.replay, "replay", "", "Serve the model responses from this cassette file instead of a provider")
+	f.IntVar(&f.maxSteps, "max-steps", 0, "Model turns allowed for the whole run (default: loop.max_steps_per_run of the config)")
+	f.BoolVar(&f.dryRun, "dry-run", false, "Print what would run, with the resolved config, without calling a model or changing a file")
+	f.BoolVar(&f.verbose, "v", false, "Verbose: print the conversation before every model request, and the tool results")
+	f.BoolVar(&f.quiet, "q", false, "Quiet: only print errors and the final messages")
+	if command == "run" || command == "plan" {
+		f.BoolVar(&f.clarify, "clarify", false, "Ask about the ambiguities of INPUT.md before planning")
+	}
+	if command == "run" {
+		f.IntVar(&f.task, "task", 0, "Only work on the task with this id, like the parallel workers do")
+		f.StringVar(&f.phase, "phase", "", "Only run this phase: "+strings.Join(phases, ", ")+"; `dev resume` continues the run after it")
+	}
+	return f
+}
+
+// apply overrides the config with the flags.
+func (f *runFlags) apply(c *Config) {
+	if f.provider != "" {
+		c.Provider = f.provider
+	}
+	if f.baseURL != "" {
+		c.BaseURL = f.baseURL
+	}
+	if f.model != "" {
+		c.SetModel(f.model)
+	}
+	if f.plannerModel != "" {
+		c.Models.Planner.Model = f.plannerModel
+	}
+	if f.executorModel != "" {
+		c.Models.Executor.Model = f.executorModel
+	}
+	if f.judgeModel != "" {
+		c.Models.Judge.Model = f.judgeModel
+	}
+	if f.maxSteps > 0 {
+		c.Loop.MaxStepsPerRun = f.maxSteps
+	}
+	if f.clarify {
+		c.Clarify = true
+	}
+}
+
+// setVerbosity applies -v and -q to the logs.
+func (f *runFlags) setVerbosity() {
+	verbose = f.verbose
+	if f.quiet {
+		verbose = false
+		log.SetOutput(io.Discard)
+	}
+}
+
+// setWorkingDirectory makes dir, "." when empty, the absolute working directory.
+func setWorkingDirectory(dir string) {
+	if dir == "" {
+		dir = "."
+	}
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		fmt.Printf("Working directory %s does not exist", dir)
+		os.Exit(1)
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		fmt.Printf("Error converting working directory to absolute path: %s", err)
+		os.Exit(1)
+	}
+	workingDirectory = abs
+}
+
+// configPath is the config file given with -config, or the one of the working directory.
+func (f *runFlags) configPath() string {
+	if f.config != "" {
+		return f.config
+	}
+	return filepath.Join(workingDirectory, ConfigFile)
+}
+
+// loadConfig loads the config file of configPath.
+func (f *runFlags) loadConfig() {
+	var err error
+	config, err = LoadConfig(f.configPath())
+	if err != nil {
+		fmt.Printf("Error loading config: %s", err)
+		os.Exit(1)
+	}
+}
+
+// setProvider creates the provider of the config, or the replay of -replay.
+func (f *runFlags) setProvider() {
+	var err error
+	if f.replay != "" {
+		cassette, err := LoadCassette(f.replay)
+		if err != nil {
+			fmt.Printf("Error loading cassette: %s", err)
+			os.Exit(1)
+		}
+		provider = NewReplayProvider(cassette)
+	} else {
+		provider, err = NewProvider(config.Provider, config.BaseURL, "")
+		if err != nil {
+			fmt.Printf("Error creating provider: %s", err)
+			os.Exit(1)
+		}
+	}
+	if p, ok := provider.(*OpenAICompatibleProvider); ok && config.ToolChoice != nil {
+		p.ToolChoice = *config.ToolChoice
+	}
+	if f.record != "" {
+		provider = NewRecordingProvider(provider, f.record)
+	}
+}
+
+// signalContext is canceled by the first Ctrl-C: in-flight model requests and commands
+// are interrupted and the session is saved. A second one kills the process.
+func signalContext() context.Context {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		cancel()
+	}()
+	return ctx
+}
+
+// runCommand runs dev run, dev plan and dev resume.
+func runCommand(command string, args []string) {
+	flags := newRunFlags(command)
+	flags.Parse(args)
+	flags.setVerbosity()
+	setWorkingDirectory(flags.Arg(0))
+
+	if flags.phase != "" && !contains(phases, flags.phase) {
+		fmt.Printf("Unknown phase %q, use one of %s\n", flags.phase, strings.Join(phases, ", "))
+		os.Exit(2)
+	}
+	if flags.task != 0 && flags.phase != "" && flags.phase != PhaseTask {
+		fmt.Printf("-task only works in the %s phase\n", PhaseTask)
+		os.Exit(2)
+	}
+
+	resume := command == "resume"
+	if command == "plan" {
+		if saved, err := LoadSession(); err == nil && saved.Phase != PhaseDone {
+			if saved.Phase != PhaseClarify && saved.Phase != PhasePlan {
+				printPlan()
+				os.Exit(0)
+			}
+			// Continue the planning that was interrupted.
+			resume = true
+		}
+	}
+
+	if resume {
+		if err := resumeSession(flags.configPath()); err != nil {
+			fmt.Printf("Error resuming session: %s", err)
+			os.Exit(1)
+		}
+		if session.Phase == PhaseDone {
+			fmt.Printf("The last run in %s already finished, nothing to resume", workingDirectory)
+			os.Exit(0)
+		}
+	} else {
+		flags.loadConfig()
+	}
+	flags.apply(&config)
+
+	if !resume {
+		session = NewSession()
+		switch {
+		case flags.task != 0:
+			session.Phase = PhaseTask
+			session.OnlyTask = flags.task
+		case flags.phase != "":
+			session.Phase = flags.phase
+			session.OnlyPhase = flags.phase
+		}
+	}
+	session.Config = config
+
+	if flags.dryRun {
+		fmt.Print(DryRun(command, resume))
+		return
+	}
+
+	flags.setProvider()
+
+	// The task and review phases work on TASKS.md, the others need INPUT.md.
+	if session.Phase == PhaseClarify || session.Phase == PhasePlan {
+		if _, err := os.Stat(filepath.Join(workingDirectory, "INPUT.md")); os.IsNotExist(err) {
+			fmt.Printf("Input file INPUT.md does not exist in the working directory %s", workingDirectory)
+			os.Create(filepath.Join(workingDirectory, "INPUT.md"))
+			os.Exit(1)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(workingDirectory, "TASKS.md")); os.IsNotExist(err) {
+		os.Create(filepath.Join(workingDirectory, "TASKS.md"))
+	}
+
+	if resume {
+		log.Printf("Resuming run from phase %s, iteration %d", session.Phase, session.Iteration)
+	}
+
+	ctx := signalContext()
+
+	if command == "plan" {
+		for session.Phase == PhaseClarify {
+			saveSession()
+			if err := clarify(ctx); err != nil {
+				stop(err)
+			}
+		}
+		if err := planTasks(ctx); err != nil {
+			stop(err)
+		}
+		saveSession()
+		if err := WriteUsageReport(); err != nil {
+			fmt.Printf("Error writing usage report: %s", err)
+		}
+		printPlan()
+		fmt.Printf("Run `dev resume` to work on the tasks.\n")
+		return
+	}
+
+	if err := run(ctx); err != nil {
+		stop(err)
+	}
+	WriteRunReport(ctx, nil)
+	if err := WriteUsageReport(); err != nil {
+		fmt.Printf("Error writing usage report: %s", err)
+	}
+	if session.Phase != PhaseDone {
+		fmt.Printf("Stopped before the %s phase.\nRun `dev resume` to continue.\n", session.Phase)
+	}
+}
+
+// resumeSession restores the run saved in .dev/session.json: its config, conversation,
+// usage and loop counters. The budget and the prices are read again from the config
+// file at configPath, so a run stopped by its budget can be resumed after raising it.
+func resumeSession(configPath string) error {
+	saved, err := LoadSession()
+	if err != nil {
+		return err
+	}
+	file, err := LoadConfig(configPath)
+	if err != nil {
+		return err
+	}
+	session = saved
+	config = session.Config
+	config.Budget = file.Budget
+	config.Prices = file.Prices
+	messages = session.Messages
+	if session.Usage != nil {
+		usage = session.Usage
+	}
+	if session.Loop != nil {
+		loop = session.Loop
+	}
+	return nil
+}
+
+// DryRun describes what a run would do, for -dry-run.
+func DryRun(command string, resume bool) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Working directory: %s\n", workingDirectory)
+	if resume {
+		fmt.Fprintf(&b, "Session: resumed in the %s phase, iteration %d\n", session.Phase, session.Iteration)
+	} else {
+		fmt.Fprintf(&b, "Session: new, starting in the %s phase\n", session.Phase)
+	}
+
+	var run []string
+	switch {
+	case session.OnlyTask != 0:
+		run = []string{fmt.Sprintf("task #%d", session.OnlyTask)}
+	case session.OnlyPhase != "":
+		run = []string{session.OnlyPhase}
+	default:
+		for _, phase := range phases {
+			if phase == session.Phase || len(run) > 0 {
+				run = append(run, phase)
+			}
+			if command == "plan" && phase == PhasePlan {
+				break
+			}
+		}
+	}
+	fmt.Fprintf(&b, "Phases: %s\n", strings.Join(run, ", "))
+
+	fmt.Fprintf(&b, "Provider: %s\n", strings.TrimSpace(config.Provider+" "+config.BaseURL))
+	fmt.Fprintf(&b, "Models: planner %s, executor %s, judge %s\n", config.Models.Planner.Model, config.Models.Executor.Model, config.Models.Judge.Model)
+	fmt.Fprintf(&b, "Max steps: %d per run, %d per task\n", config.Loop.MaxStepsPerRun, config.Loop.MaxStepsPerTask)
+
+	content, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		fmt.Fprintf(&b, "Error encoding the config: %s\n", err)
+	} else {
+		fmt.Fprintf(&b, "\nConfig:\n%s\n", content)
+	}
+	fmt.Fprintf(&b, "\nTasks:\n%s\n", strings.TrimRight(ListTasks(), "\n"))
+	return b.String()
+}
+
+// wikiCommand runs dev wiki. The conversation is not saved, so it does not touch the
+// session of a run.
+func wikiCommand(args []string) {
+	flags := newRunFlags("wiki")
+	flags.Parse(args)
+	flags.setVerbosity()
+	setWorkingDirectory(flags.Arg(0))
+	flags.loadConfig()
+	flags.apply(&config)
+
+	session = NewSession()
+	session.Phase = PhaseWiki
+	session.Config = config
+	session.Ephemeral = true
+
+	if flags.dryRun {
+		fmt.Printf("Working directory: %s\nWould write the wiki of the project to %s with %s\n", workingDirectory, Path("wiki"), config.Models.Executor.Model)
+		return
+	}
+	flags.setProvider()
+
+	if err := GenWiki(signalContext()); err != nil {
+		fmt.Printf("Error writing the wiki: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("The wiki was written to %s, %d model calls, $%.4f\n", Path("wiki"), usage.Run.Calls, usage.Run.Cost)
+}
+
+// toolsCommand runs dev tools list.
+func toolsCommand(args []string) {
+	if len(args) != 1 || args[0] != "list" {
+		fmt.Printf("Usage: dev tools list\n")
+		os.Exit(2)
+	}
+	fmt.Print(FormatTools(GetTools()))
+}
+
+// FormatTools renders the tools for `dev tools list`, one per line with whether they
+// change the working directory.
+func FormatTools(tools []openai.Tool) string {
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 4, 2, ' ', 0)
+	for _, tool := range tools {
+		kind := "write"
+		if IsReadOnlyTool(tool.Function.Name) {
+			kind = "read-only"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", tool.Function.Name, kind, firstLine(tool.Function.Description))
+	}
+	w.Flush()
+	return b.String()
+}
+
+// reportCommand runs dev report.
+func reportCommand(args []string) {
+	flags := flag.NewFlagSet("report", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "Print the JSON report instead of the markdown one")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: dev report [flags] [working directory]\n\nFlags:\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	setWorkingDirectory(flags.Arg(0))
+
+	path, err := LatestReport()
+	if err != nil {
+		fmt.Printf("%s\n", err)
+		os.Exit(1)
+	}
+	if *asJSON {
+		path = strings.TrimSuffix(path, ".md") + ".json"
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Printf("Error reading the report: %s\n", err)
+		os.Exit(1)
+	}
+	os.Stdout.Write(content)
+}
+
+// Version is the version of the build: the release version, or the commit it was built from.
+func Version() string {
+	if version != "" {
+		return version
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "(devel)"
+	}
+	if info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	revision, modified := "", false
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value
+		case "vcs.modified":
+			modified = setting.Value == "true"
+		}
+	}
+	if revision == "" {
+		return "(devel)"
+	}
+	if len(revision) > 12 {
+		revision = revision[:12]
+	}
+	if modified {
+		revision += "-dirty"
+	}
+	return "(devel) " + revision
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunOnlyPhase(t *testing.T) {
+	const model = "test-model"
+	cassette := &Cassette{Interactions: []Interaction{
+		toolCallInteraction(model, call("submit_plan", map[string]any{"tasks": []map[string]any{
+			{"id": 1, "title": "Write hello.txt", "size": "s"},
+		}})),
+		textInteraction(model, "Planned."),
+	}}
+	replay := setupRun(t, "Write hello.txt\n", cassette)
+	session.Phase = PhasePlan
+	session.OnlyPhase = PhasePlan
+
+	if err := run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if replay.Remaining() != 0 {
+		t.Errorf("Expected the whole cassette to be replayed, %d interactions left", replay.Remaining())
+	}
+	if session.Phase != PhaseTask || session.OnlyPhase != "" {
+		t.Errorf("Expected the run to stop before the task phase and be resumable, got phase %s, only phase %q", session.Phase, session.OnlyPhase)
+	}
+	saved, err := LoadSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Phase != PhaseTask || saved.OnlyPhase != "" {
+		t.Errorf("Expected the saved session to continue with the tasks, got phase %s, only phase %q", saved.Phase, saved.OnlyPhase)
+	}
+}
+
+func TestRunFlags(t *testing.T) {
+	workingDirectory = t.TempDir()
+	config = DefaultConfig()
+	t.Cleanup(func() {
+		config = Config{}
+		session = nil
+	})
+
+	flags := newRunFlags("run")
+	if err := flags.Parse([]string{"-model", "flag-model", "-max-steps", "7", "-clarify", "-phase", "review", "dir"}); err != nil {
+		t.Fatal(err)
+	}
+	flags.apply(&config)
+	if config.Models.Planner.Model != "flag-model" || config.Models.Judge.Model != "flag-model" {
+		t.Errorf("Expected -model to set every role, got %+v", config.Models)
+	}
+	if config.Loop.MaxStepsPerRun != 7 || !config.Clarify {
+		t.Errorf("Expected -max-steps and -clarify to be applied, got %d, %v", config.Loop.MaxStepsPerRun, config.Clarify)
+	}
+	if flags.phase != PhaseReview || flags.Arg(0) != "dir" {
+		t.Errorf("Unexpected phase %q and working directory %q", flags.phase, flags.Arg(0))
+	}
+	if newRunFlags("resume").Lookup("phase") != nil {
+		t.Errorf("Expected -phase to only be a flag of dev run")
+	}
+
+	for _, tt := range []struct {
+		command string
+		clarify bool
+		phase   string
+		want    string
+	}{
+		{"run", false, "", "Phases: plan, task, review\n"},
+		{"run", true, "", "Phases: clarify, plan, task, review\n"},
+		{"plan", true, "", "Phases: clarify, plan\n"},
+		{"run", false, PhaseTask, "Phases: task\n"},
+	} {
+		config.Clarify = tt.clarify
+		session = NewSession()
+		if tt.phase != "" {
+			session.Phase = tt.phase
+			session.OnlyPhase = tt.phase
+		}
+		if got := DryRun(tt.command, false); !strings.Contains(got, tt.want) {
+			t.Errorf("dev %s with clarify %v and phase %q: expected %q in:\n%s", tt.command, tt.clarify, tt.phase, tt.want, got)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(workingDirectory, ".dev")); !os.IsNotExist(err) {
+		t.Errorf("Expected a dry run to leave the working directory alone")
+	}
+}
+
+func TestFormatTools(t *testing.T) {
+	listed := FormatTools(GetTools())
+	lines := strings.Split(strings.TrimSpace(listed), "\n")
+	if len(lines) != len(GetTools()) {
+		t.Fatalf("Expected a line per tool, got:\n%s", listed)
+	}
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		switch fields[0] {
+		case "read_file":
+			if fields[1] != "read-only" {
+				t.Errorf("Expected read_file to be read-only: %s", line)
+			}
+		case "write_file":
+			if fields[1] != "write" {
+				t.Errorf("Expected write_file to be a write: %s", line)
+			}
+		}
+	}
+}
+
+func TestLatestReport(t *testing.T) {
+	workingDirectory = t.TempDir()
+	if _, err := LatestReport(); err == nil {
+		t.Errorf("Expected an error without reports")
+	}
+
+	dir := filepath.Join(workingDirectory, ".dev", "reports")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"20250102-100000.md", "20250102-100000.json", "20250103-090000.md", "20250101-230000.md"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path, err := LatestReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(path) != "20250103-090000.md" {
+		t.Errorf("Expected the report of the last run, got %s", path)
+	}
+}
+
+func TestResumeSession(t *testing.T) {
+	const model = "test-model"
+	cassette := &Cassette{Interactions: []Interaction{
+		toolCallInteraction(model, call("add_task", map[string]any{"title": "Create hello.txt"})),
+		textInteraction(model, "Planned."),
+		toolCallInteraction(model, call("write_file", map[string]any{"path": "hello.txt", "content": "hello\n"})),
+		toolCallInteraction(model, call("complete_task", map[string]any{"id": 1})),
+		textInteraction(model, "Created hello.txt."),
+		verdictInteraction(model, "yes"),
+	}}
+	replay := setupRun(t, "Create hello.txt\n", cassette)
+	config.Budget = Budget{MaxCalls: 3}
+	session.Config = config
+
+	// The run stops in the middle of the task conversation.
+	var budgetErr *BudgetError
+	if err := run(context.Background()); !errors.As(err, &budgetErr) {
+		t.Fatalf("Expected a BudgetError, got %v", err)
+	}
+	saveSession()
+	saved := struct {
+		messages int
+		calls    int
+		steps    int
+	}{len(messages), usage.Run.Calls, loop.RunSteps}
+	if saved.messages == 0 || saved.calls != 3 || saved.steps == 0 {
+		t.Fatalf("Unexpected state before resuming: %+v", saved)
+	}
+
+	// The budget is raised in the config file before resuming.
+	configPath := filepath.Join(workingDirectory, ConfigFile)
+	if err := os.WriteFile(configPath, []byte(`{"budget": {"max_calls": 10}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	session, messages, usage, loop, config = nil, nil, &UsageTracker{}, &LoopDetector{}, Config{}
+	if err := resumeSession(configPath); err != nil {
+		t.Fatal(err)
+	}
+	if session.Phase != PhaseTask || session.TaskID != 1 {
+		t.Errorf("Expected to resume task #1, got phase %s, task %d", session.Phase, session.TaskID)
+	}
+	if len(messages) != saved.messages || usage.Run.Calls != saved.calls || loop.RunSteps != saved.steps {
+		t.Errorf("Expected %+v to be restored, got %d messages, %d calls, %d steps", saved, len(messages), usage.Run.Calls, loop.RunSteps)
+	}
+	if config.Models.Executor.Model != model || config.Retry.MaxAttempts != 1 {
+		t.Errorf("Expected the config of the run to be restored, got %+v", config)
+	}
+	if config.Budget.MaxCalls != 10 {
+		t.Errorf("Expected the budget of the config file, got %+v", config.Budget)
+	}
+
+	// The restored conversation goes on where it stopped.
+	if err := run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if replay.Remaining() != 0 || session.Phase != PhaseDone || usage.Run.Calls != 6 {
+		t.Errorf("Expected the resumed run to finish, %d interactions left, phase %s, %d calls", replay.Remaining(), session.Phase, usage.Run.Calls)
+	}
+	if hello, _ := os.ReadFile(filepath.Join(workingDirectory, "hello.txt")); string(hello) != "hello\n" {
+		t.Errorf("Expected hello.txt to be written, got %q", hello)
+	}
+}
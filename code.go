@@ -2,12 +2,14 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"go/ast"
 	"go/parser"
@@ -17,19 +19,16 @@ import (
 	"golang.org/x/tools/imports"
 )
 
-func Lint(path string) string {
+func Lint(ctx context.Context, path string) string {
 	path = Path(path)
 	dir := filepath.Dir(path)
 
-	command := exec.Command("go", "mod", "tidy")
-	command.Dir = dir
-	output, err := command.CombinedOutput()
+	output, err := lintCommand(ctx, dir, "go", "mod", "tidy")
 	if err != nil && len(output) == 0 {
 		return fmt.Sprintf("Error formatting go file: %s", err)
 	}
 
-	command = exec.Command("go", "vet", dir)
-	output, err = command.CombinedOutput()
+	output, err = lintCommand(ctx, dir, "go", "vet", ".")
 	if err != nil && len(output) == 0 {
 		return fmt.Sprintf("Error formatting go file: %s", err)
 	}
@@ -37,8 +36,7 @@ func Lint(path string) string {
 		return string(output)
 	}
 
-	command = exec.Command("go", "fmt", dir)
-	output, err = command.CombinedOutput()
+	output, err = lintCommand(ctx, dir, "go", "fmt", ".")
 	if err != nil && len(output) == 0 {
 		return fmt.Sprintf("Error formatting go file: %s", err)
 	}
@@ -50,6 +48,16 @@ func Lint(path string) string {
 	return "No errors found"
 }
 
+// lintCommand runs one command of lint_file in dir and records it for the run report.
+func lintCommand(ctx context.Context, dir string, args ...string) ([]byte, error) {
+	start := time.Now()
+	command := exec.CommandContext(ctx, args[0], args[1:]...)
+	command.Dir = dir
+	output, err := command.CombinedOutput()
+	recordCommand("lint_file", strings.Join(args, " "), err == nil, string(output), err, start)
+	return output, err
+}
+
 // This function reads the code of the specified functions from the specified file.
 // It also returns the rest of the function signatures (without the body) in the file
 // and the structs, interfaces and types in the file.
@@ -76,7 +84,7 @@ func ReadCode(path string, functions ...string) string {
 		packageName := strings.TrimSpace(strings.Split(modString, "\n")[0])
 		// Create from template
 		content = []byte(fmt.Sprintf("package %s\n\n", packageName))
-		if err := os.WriteFile(path, content, 0644); err != nil {
+		if err := writeFileAtomic(path, content, 0644); err != nil {
 			return fmt.Sprintf("Error creating file: %s", err)
 		}
 	}
@@ -214,7 +222,7 @@ func AddOrEditFunction(path string, functionName string, functionBody string) st
 		return fmt.Sprintf("Error writing file: %s", err)
 	}
 
-	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+	if err := writeFileAtomic(path, buf.Bytes(), 0644); err != nil {
 		return fmt.Sprintf("Error saving file: %s", err)
 	}
 
@@ -241,7 +249,7 @@ func autoImport(path string) {
 
 	// If the processed content is different from the original, write it back
 	if !bytes.Equal(src, processedSrc) {
-		err = os.WriteFile(filename, processedSrc, 0644)
+		err = writeFileAtomic(filename, processedSrc, 0644)
 		if err != nil {
 			log.Printf("Error writing processed file: %v", err)
 		}
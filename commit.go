@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"dev/tasks"
+)
+
+// TaskTrailer maps a commit to the TASKS.md entry it completes.
+const TaskTrailer = "Task-Id"
+
+// MaxSubjectChars bounds the subject line of the generated commit messages.
+const MaxSubjectChars = 72
+
+// commitExclude keeps the run state out of the task commits. The task metadata is left
+// out too, since it records the commit.
+func commitExclude() []string {
+	meta, _ := filepath.Rel(workingDirectory, tasks.MetaPath(TasksPath()))
+	return []string{":(exclude).dev", ":(exclude)" + meta}
+}
+
+// commitTask commits the changes of a completed task and records the commit in the
+// task metadata. It does nothing outside of a git repository or without changes.
+func commitTask(ctx context.Context, id int, response string) error {
+	repo := WorkingRepo()
+	if !repo.IsRepo(ctx) {
+		log.Printf("Not a git repository, not committing task #%d", id)
+		return nil
+	}
+
+	tasksMu.Lock()
+	defer tasksMu.Unlock()
+	doc, err := LoadTasks()
+	if err != nil {
+		return fmt.Errorf("reading TASKS.md: %w", err)
+	}
+	task := doc.Find(id)
+	if task == nil {
+		return fmt.Errorf("no task with id %d", id)
+	}
+
+	paths := append([]string{"."}, commitExclude()...)
+	if err := repo.Stage(ctx, paths...); err != nil {
+		return err
+	}
+	stat, err := repo.Diff(ctx, DiffOptions{Staged: true, Stat: true})
+	if err != nil {
+		return err
+	}
+	hash, err := repo.Commit(ctx, CommitMessage(task, response, stat), paths...)
+	if errors.Is(err, ErrNothingToCommit) {
+		log.Printf("Task #%d changed nothing, not committing", id)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("Committed task #%d as %s", id, hash)
+
+	doc.Meta(id).Commit = hash
+	return doc.Save(TasksPath())
+}
+
+// commitCompletedTask commits the task of the current iteration when it was completed.
+func commitCompletedTask(ctx context.Context) error {
+	doc, err := LoadTasks()
+	if err != nil {
+		return fmt.Errorf("reading TASKS.md: %w", err)
+	}
+	task := doc.Find(session.TaskID)
+	if task == nil || task.State != tasks.Done || doc.Meta(task.ID).Commit != "" {
+		return nil
+	}
+	return commitTask(ctx, task.ID, session.Response)
+}
+
+// CommitMessage generates the commit message of a task from its title, the summary
+// the model gave and the diff stat, with the task id as a trailer.
+func CommitMessage(task *tasks.Task, response, stat string) string {
+	subject := strings.TrimSuffix(strings.TrimSpace(task.Title), ".")
+	if runes := []rune(subject); len(runes) > MaxSubjectChars {
+		subject = strings.TrimSpace(string(runes[:MaxSubjectChars-3])) + "..."
+	}
+
+	var b strings.Builder
+	b.WriteString(subject + "\n")
+	if summary := firstParagraph(response); summary != "" && summary != task.Title {
+		b.WriteString("\n" + summary + "\n")
+	}
+	if stat = strings.TrimRight(stat, "\n"); stat != "" {
+		b.WriteString("\n" + stat + "\n")
+	}
+	fmt.Fprintf(&b, "\n%s: %d\n", TaskTrailer, task.ID)
+	return b.String()
+}
+
+func firstParagraph(text string) string {
+	text = strings.TrimSpace(text)
+	if i := strings.Index(text, "\n\n"); i >= 0 {
+		text = text[:i]
+	}
+	return text
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestCommitTask(t *testing.T) {
+	workingDirectory = t.TempDir()
+	repo := initRepo(t)
+	ctx := context.Background()
+
+	os.WriteFile(TasksPath(), []byte("- [x] Create hello.txt. <!-- id:1 -->\n"), 0644)
+	os.WriteFile(filepath.Join(workingDirectory, "hello.txt"), []byte("hello\n"), 0644)
+	os.MkdirAll(filepath.Join(workingDirectory, ".dev"), 0755)
+	os.WriteFile(filepath.Join(workingDirectory, ".dev", "session.json"), []byte("{}"), 0644)
+
+	if err := commitTask(ctx, 1, "Created hello.txt saying hello.\n\nNothing else."); err != nil {
+		t.Fatal(err)
+	}
+	message, _ := repo.git(ctx, "log", "-1", "--format=%B")
+	if !strings.HasPrefix(message, "Create hello.txt\n\nCreated hello.txt saying hello.\n\n") || strings.Contains(message, "Nothing else") {
+		t.Errorf("Unexpected commit message:\n%s", message)
+	}
+	if trailer, _ := repo.git(ctx, "log", "-1", "--format=%(trailers:key=Task-Id,valueonly)"); strings.TrimSpace(trailer) != "1" {
+		t.Errorf("Expected the Task-Id trailer to be 1, got %q", trailer)
+	}
+	files, _ := repo.git(ctx, "show", "--name-only", "--format=", "HEAD")
+	if strings.Join(strings.Fields(files), ",") != "TASKS.md,hello.txt" {
+		t.Errorf("Expected the run state to be left out of the commit, got %q", files)
+	}
+
+	doc, _ := LoadTasks()
+	head, _ := repo.Head(ctx)
+	if commit := doc.Meta(1).Commit; commit != head {
+		t.Errorf("Expected the commit to be recorded in the task metadata, got %q instead of %q", commit, head)
+	}
+
+	if err := commitTask(ctx, 1, ""); err != nil {
+		t.Errorf("Expected nothing to commit to be fine, got %v", err)
+	}
+	if after, _ := repo.Head(ctx); after != head {
+		t.Errorf("Expected no new commit without changes")
+	}
+
+	doc.Find(1).Title = strings.Repeat("word ", 20)
+	if subject := strings.SplitN(CommitMessage(doc.Find(1), "", ""), "\n", 2)[0]; len(subject) > MaxSubjectChars || !strings.HasSuffix(subject, "...") {
+		t.Errorf("Expected the subject to be truncated, got %q", subject)
+	}
+	doc.Find(1).Title = strings.Repeat("é", 100)
+	if subject := strings.SplitN(CommitMessage(doc.Find(1), "", ""), "\n", 2)[0]; !utf8.ValidString(subject) || utf8.RuneCountInString(subject) != MaxSubjectChars {
+		t.Errorf("Expected the subject to be truncated on a character boundary, got %q", subject)
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+const (
+	DefaultContextWindow = 128000
+	// Compaction starts when the conversation uses this fraction of the window.
+	CompactThreshold = 0.8
+	// Number of trailing messages that are never summarised.
+	KeepRecentMessages = 12
+	// Tool outputs in the kept messages are truncated to this many characters as a last resort.
+	MaxToolOutputChars = 20000
+)
+
+// Context windows, in tokens, of the models we know about. ModelConfig.ContextWindow overrides them.
+var contextWindows = map[string]int{
+	"openai/gpt-4.1":                  1047576,
+	"openai/gpt-4.1-mini":             1047576,
+	"openai/gpt-4o":                   128000,
+	"openai/gpt-4o-mini":              128000,
+	"google/gemini-2.5-flash-preview": 1048576,
+	"google/gemini-2.5-pro-preview":   1048576,
+	"anthropic/claude-3.7-sonnet":     200000,
+	"claude-3-7-sonnet-latest":        200000,
+	"claude-sonnet-4-0":               200000,
+}
+
+func (m ModelConfig) contextWindow() int {
+	if m.ContextWindow > 0 {
+		return m.ContextWindow
+	}
+	if window, ok := contextWindows[m.Model]; ok {
+		return window
+	}
+	return DefaultContextWindow
+}
+
+// charsPerToken is a rough ratio for the tokenizer family of the model.
+func charsPerToken(model string) float64 {
+	switch {
+	case strings.Contains(model, "claude"):
+		return 3.5
+	case strings.Contains(model, "gemini"):
+		return 4.0
+	}
+	return 3.8
+}
+
+// EstimateTokens approximates the prompt size of messages for the given model.
+func EstimateTokens(model string, messages []openai.ChatCompletionMessage) int {
+	chars := 0
+	for _, message := range messages {
+		chars += len(message.Content)
+		for _, toolCall := range message.ToolCalls {
+			chars += len(toolCall.Function.Name) + len(toolCall.Function.Arguments)
+		}
+	}
+	// Every message has a few tokens of framing.
+	return int(float64(chars)/charsPerToken(model)) + 4*len(messages)
+}
+
+func estimateToolTokens(model string, tools []openai.Tool) int {
+	data, err := json.Marshal(tools)
+	if err != nil {
+		return 0
+	}
+	return int(float64(len(data)) / charsPerToken(model))
+}
+
+// compactMessages shrinks the global conversation before a request so that it fits
+// the context window of the model.
+func compactMessages(ctx context.Context, model ModelConfig) {
+	messages = Compact(messages, model, estimateToolTokens(model.Model, GetTools()), func(old []openai.ChatCompletionMessage) (string, error) {
+		return summarize(ctx, model, old)
+	})
+}
+
+// Compact returns messages reduced to fit the model context window, in three steps:
+//  1. outputs of read_file/read_code that are not in the recent messages are dropped,
+//  2. older turns are replaced by a summary, keeping the leading system and task messages,
+//  3. long tool outputs in the recent messages are truncated.
+func Compact(messages []openai.ChatCompletionMessage, model ModelConfig, reserved int, summarizer func([]openai.ChatCompletionMessage) (string, error)) []openai.ChatCompletionMessage {
+	maxTokens := model.MaxTokens
+	if maxTokens == 0 {
+		maxTokens = 4096
+	}
+	budget := int(float64(model.contextWindow()-maxTokens-reserved) * CompactThreshold)
+	fits := func() bool {
+		return EstimateTokens(model.Model, messages) <= budget
+	}
+	if fits() {
+		return messages
+	}
+	log.Printf("Compacting conversation: ~%d tokens, budget %d", EstimateTokens(model.Model, messages), budget)
+
+	head := framing(messages)
+	tail := safeTailStart(messages, head)
+
+	// 1. Drop stale file reads.
+	toolNames := map[string]string{}
+	for _, message := range messages {
+		for _, toolCall := range message.ToolCalls {
+			toolNames[toolCall.ID] = toolCall.Function.Name
+		}
+	}
+	compacted := make([]openai.ChatCompletionMessage, len(messages))
+	copy(compacted, messages)
+	for i := head; i < tail; i++ {
+		message := compacted[i]
+		if message.Role != openai.ChatMessageRoleTool {
+			continue
+		}
+		if name := toolNames[message.ToolCallID]; name == "read_file" || name == "read_code" {
+			message.Content = fmt.Sprintf("[stale %s output removed to save context, call the tool again if needed]", name)
+			compacted[i] = message
+		}
+	}
+	messages = compacted
+	if fits() {
+		return messages
+	}
+
+	// 2. Summarise the old turns.
+	if tail > head {
+		var summary string
+		var err error
+		if summarizer != nil {
+			summary, err = summarizer(messages[head:tail])
+		}
+		if summarizer == nil || err != nil || summary == "" {
+			if err != nil {
+				log.Printf("Error summarising conversation: %s", err)
+			}
+			summary = fmt.Sprintf("%d earlier messages were removed to save context.", tail-head)
+		}
+		compacted = append([]openai.ChatCompletionMessage{}, messages[:head]...)
+		compacted = append(compacted, openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleUser,
+			Content: "Summary of the work done so far in this conversation:\n\n" + summary,
+		})
+		compacted = append(compacted, messages[tail:]...)
+		messages = compacted
+		if fits() {
+			return messages
+		}
+	}
+
+	// 3. Truncate what is left.
+	for i, message := range messages {
+		if message.Role == openai.ChatMessageRoleTool && len(message.Content) > MaxToolOutputChars {
+			message.Content = message.Content[:MaxToolOutputChars] + "\n[output truncated]"
+			messages[i] = message
+		}
+	}
+	return messages
+}
+
+// framing returns the number of leading messages that frame the task (system prompts
+// and the first user message) and are always kept.
+func framing(messages []openai.ChatCompletionMessage) int {
+	head := 0
+	for head < len(messages) && messages[head].Role == openai.ChatMessageRoleSystem {
+		head++
+	}
+	if head < len(messages) && messages[head].Role == openai.ChatMessageRoleUser {
+		head++
+	}
+	return head
+}
+
+// safeTailStart returns the index of the first recent message to keep, making sure the
+// kept part does not start with tool results whose assistant message would be dropped.
+func safeTailStart(messages []openai.ChatCompletionMessage, start int) int {
+	tail := len(messages) - KeepRecentMessages
+	if tail < start {
+		return start
+	}
+	for tail > start && messages[tail].Role == openai.ChatMessageRoleTool {
+		tail--
+	}
+	return tail
+}
+
+func summarize(ctx context.Context, model ModelConfig, old []openai.ChatCompletionMessage) (string, error) {
+	var transcript strings.Builder
+	for _, message := range old {
+		content := message.Content
+		if len(content) > 2000 {
+			content = content[:2000] + "..."
+		}
+		for _, toolCall := range message.ToolCalls {
+			content += fmt.Sprintf("\n[tool call] %s %s", toolCall.Function.Name, toolCall.Function.Arguments)
+		}
+		transcript.WriteString(fmt.Sprintf("%s: %s\n\n", message.Role, content))
+	}
+
+	response, err := createChatCompletion(
+		ctx,
+		openai.ChatCompletionRequest{
+			Model: model.Model,
+			Messages: []openai.ChatCompletionMessage{
+				{
+					Role:    openai.ChatMessageRoleSystem,
+					Content: "Summarise the following agent transcript. Keep the decisions taken, the files created or modified, the commands run with their results and anything still pending. Be concise.",
+				},
+				{
+					Role:    openai.ChatMessageRoleUser,
+					Content: transcript.String(),
+				},
+			},
+		},
+	)
+	if err != nil {
+		return "", err
+	}
+	if len(response.Choices) == 0 {
+		return "", fmt.Errorf("empty summary response")
+	}
+	return response.Choices[0].Message.Content, nil
+}
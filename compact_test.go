@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func readTurn(id string, content string) []openai.ChatCompletionMessage {
+	return []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleAssistant, ToolCalls: []openai.ToolCall{
+			{ID: id, Type: openai.ToolTypeFunction, Function: openai.FunctionCall{Name: "read_file", Arguments: `{"path":"a.txt"}`}},
+		}},
+		{Role: openai.ChatMessageRoleTool, ToolCallID: id, Content: content},
+	}
+}
+
+func TestCompact(t *testing.T) {
+	model := ModelConfig{Model: "test", ContextWindow: 20000, MaxTokens: 1000}
+	big := strings.Repeat("x", 8000)
+
+	conversation := []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: "system"},
+		{Role: openai.ChatMessageRoleUser, Content: "task"},
+	}
+	for i := 0; i < 10; i++ {
+		conversation = append(conversation, readTurn(fmt.Sprintf("call_%d", i), big)...)
+	}
+
+	t.Run("fits without changes", func(t *testing.T) {
+		small := conversation[:4]
+		if got := Compact(small, model, 0, nil); len(got) != len(small) || got[3].Content != big {
+			t.Errorf("Expected conversation to be unchanged")
+		}
+	})
+
+	t.Run("drops stale reads", func(t *testing.T) {
+		got := Compact(conversation, model, 0, nil)
+		if got[0].Content != "system" || got[1].Content != "task" {
+			t.Fatalf("Expected framing messages to be kept, got %+v", got[:2])
+		}
+		if !strings.HasPrefix(got[3].Content, "[stale read_file output removed") {
+			t.Errorf("Expected old read_file output to be dropped, got %q", got[3].Content[:20])
+		}
+		if got[len(got)-1].Content != big {
+			t.Errorf("Expected the latest read to be kept")
+		}
+		if EstimateTokens(model.Model, got) > 20000 {
+			t.Errorf("Expected compacted conversation to fit, got ~%d tokens", EstimateTokens(model.Model, got))
+		}
+	})
+
+	t.Run("summarises old turns", func(t *testing.T) {
+		tight := ModelConfig{Model: "test", ContextWindow: 8000, MaxTokens: 1000}
+		var summarised int
+		got := Compact(conversation, tight, 0, func(old []openai.ChatCompletionMessage) (string, error) {
+			summarised = len(old)
+			return "read a.txt many times", nil
+		})
+		if summarised == 0 {
+			t.Fatalf("Expected summarizer to be called")
+		}
+		if !strings.Contains(got[2].Content, "read a.txt many times") {
+			t.Errorf("Expected summary after framing messages, got %q", got[2].Content)
+		}
+		if got[3].Role == openai.ChatMessageRoleTool {
+			t.Errorf("Expected kept messages not to start with a tool result")
+		}
+	})
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+const ConfigFile = ".dev.json"
+
+// ModelConfig selects the model and sampling settings used for one role.
+type ModelConfig struct {
+	Model string `json:"model"`
+	// Temperature is only sent when it is set, 0 included, otherwise the provider
+	// default is used.
+	Temperature *float32 `json:"temperature,omitempty"`
+	MaxTokens   int      `json:"max_tokens,omitempty"`
+	// ContextWindow overrides the known context window of the model, in tokens.
+	ContextWindow int `json:"context_window,omitempty"`
+}
+
+// Models holds a model per role:
+// the planner turns INPUT.md into tasks, the executor works on the tasks
+// and the judge answers the yes/no questions that drive the main loop.
+type Models struct {
+	Planner  ModelConfig `json:"planner"`
+	Executor ModelConfig `json:"executor"`
+	Judge    ModelConfig `json:"judge"`
+	// Escalation is the stronger model switched to when the agent is stuck, see LoopConfig.
+	Escalation ModelConfig `json:"escalation,omitempty"`
+}
+
+type Config struct {
+	Provider string `json:"provider,omitempty"`
+	BaseURL  string `json:"base_url,omitempty"`
+	Models   Models `json:"models"`
+
+	Retry  RetryConfig      `json:"retry"`
+	Budget Budget           `json:"budget"`
+	Prices map[string]Price `json:"prices,omitempty"`
+
+	// MaxParallelTools bounds the read-only tool calls run at the same time.
+	MaxParallelTools int `json:"max_parallel_tools,omitempty"`
+
+	Loop LoopConfig `json:"loop"`
+
+	// Gates must all pass before a run is finished.
+	Gates []Gate `json:"gates,omitempty"`
+
+	// Clarify asks about the ambiguities of INPUT.md before planning.
+	Clarify bool `json:"clarify,omitempty"`
+
+	// Parallel is how many independent tasks can run at the same time, each in its own
+	// git worktree. 0 and 1 run one task at a time.
+	Parallel int `json:"parallel,omitempty"`
+
+	// AutoCommit commits the changes of every completed task.
+	AutoCommit bool `json:"auto_commit,omitempty"`
+
+	// ToolChoice can be set to false for OpenAI-compatible servers that cannot force the
+	// model to call a tool, like some llama.cpp and vLLM setups. The judge then only offers
+	// its verdict tool.
+	ToolChoice *bool `json:"tool_choice,omitempty"`
+
+	// JudgeSamples is how many times the judge is asked; the majority wins.
+	JudgeSamples int `json:"judge_samples,omitempty"`
+}
+
+var config Config
+
+func DefaultConfig() Config {
+	judgeTemperature := float32(0.7)
+	return Config{
+		Provider: "openrouter",
+		Models: Models{
+			Planner:  ModelConfig{Model: "openai/gpt-4.1-mini"},
+			Executor: ModelConfig{Model: "openai/gpt-4.1-mini"},
+			Judge:    ModelConfig{Model: "openai/gpt-4.1-mini", Temperature: &judgeTemperature},
+		},
+		Retry:            RetryConfig{MaxAttempts: 6, InitialDelay: 2, MaxDelay: 120},
+		MaxParallelTools: 4,
+		JudgeSamples:     1,
+		Gates:            []Gate{{Name: "gofmt"}, {Name: "vet"}, {Name: "build"}, {Name: "test"}},
+		Loop: LoopConfig{
+			MaxRepeatedToolCalls:   3,
+			MaxUnchangedIterations: 3,
+			MaxStepsPerTask:        50,
+			MaxStepsPerRun:         1000,
+			MaxTaskAttempts:        3,
+			Action:                 LoopNudge,
+		},
+	}
+}
+
+// LoadConfig reads the config file on top of the defaults and then applies the
+// environment overrides. A missing file is not an error.
+func LoadConfig(path string) (Config, error) {
+	cfg := DefaultConfig()
+
+	content, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return cfg, fmt.Errorf("reading %s: %w", path, err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(content, &cfg); err != nil {
+			return cfg, fmt.Errorf("parsing %s: %w", path, err)
+		}
+	}
+
+	if err := cfg.applyEnv(); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// applyEnv overrides the config with DEV_* environment variables.
+// DEV_MODEL sets every role, DEV_<ROLE>_MODEL, DEV_<ROLE>_TEMPERATURE and
+// DEV_<ROLE>_MAX_TOKENS set a single role.
+func (c *Config) applyEnv() error {
+	if v := os.Getenv("DEV_PROVIDER"); v != "" {
+		c.Provider = v
+	}
+	if v := os.Getenv("DEV_BASE_URL"); v != "" {
+		c.BaseURL = v
+	}
+	if v := os.Getenv("DEV_MODEL"); v != "" {
+		c.SetModel(v)
+	}
+
+	roles := map[string]*ModelConfig{
+		"PLANNER":  &c.Models.Planner,
+		"EXECUTOR": &c.Models.Executor,
+		"JUDGE":    &c.Models.Judge,
+	}
+	for role, model := range roles {
+		if v := os.Getenv("DEV_" + role + "_MODEL"); v != "" {
+			model.Model = v
+		}
+		if v := os.Getenv("DEV_" + role + "_TEMPERATURE"); v != "" {
+			temperature, err := strconv.ParseFloat(v, 32)
+			if err != nil {
+				return fmt.Errorf("DEV_%s_TEMPERATURE: %w", role, err)
+			}
+			t := float32(temperature)
+			model.Temperature = &t
+		}
+		if v := os.Getenv("DEV_" + role + "_MAX_TOKENS"); v != "" {
+			maxTokens, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("DEV_%s_MAX_TOKENS: %w", role, err)
+			}
+			model.MaxTokens = maxTokens
+		}
+	}
+	return nil
+}
+
+// SetModel uses the same model for every role, keeping their sampling settings.
+func (c *Config) SetModel(model string) {
+	c.Models.Planner.Model = model
+	c.Models.Executor.Model = model
+	c.Models.Judge.Model = model
+}
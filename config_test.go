@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	for _, name := range []string{"DEV_PROVIDER", "DEV_BASE_URL", "DEV_MODEL"} {
+		t.Setenv(name, "")
+	}
+	for _, role := range []string{"PLANNER", "EXECUTOR", "JUDGE"} {
+		for _, setting := range []string{"MODEL", "TEMPERATURE", "MAX_TOKENS"} {
+			t.Setenv("DEV_"+role+"_"+setting, "")
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), ConfigFile)
+	if err := os.WriteFile(path, []byte(`{"models": {
+		"planner":  {"model": "file-planner", "max_tokens": 100},
+		"executor": {"model": "file-executor", "max_tokens": 100},
+		"judge":    {"model": "file-judge", "max_tokens": 100}
+	}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	roles := map[string]func(c *Config) *ModelConfig{
+		"planner":  func(c *Config) *ModelConfig { return &c.Models.Planner },
+		"executor": func(c *Config) *ModelConfig { return &c.Models.Executor },
+		"judge":    func(c *Config) *ModelConfig { return &c.Models.Judge },
+	}
+	for role, model := range roles {
+		for _, tt := range []struct {
+			name          string
+			env           string
+			flag          string
+			want          string
+			wantMaxTokens int
+		}{
+			{name: "file", want: "file-" + role, wantMaxTokens: 100},
+			{name: "env over file", env: "env-" + role, want: "env-" + role, wantMaxTokens: 200},
+			{name: "flag over env", env: "env-" + role, flag: "flag-" + role, want: "flag-" + role, wantMaxTokens: 200},
+			{name: "flag over file", flag: "flag-" + role, want: "flag-" + role, wantMaxTokens: 100},
+		} {
+			t.Run(role+"/"+tt.name, func(t *testing.T) {
+				env := "DEV_" + strings.ToUpper(role)
+				if tt.env != "" {
+					t.Setenv(env+"_MODEL", tt.env)
+					t.Setenv(env+"_MAX_TOKENS", "200")
+				}
+				cfg, err := LoadConfig(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				flags := newRunFlags("run")
+				var args []string
+				if tt.flag != "" {
+					args = []string{"-" + role + "-model", tt.flag}
+				}
+				if err := flags.Parse(args); err != nil {
+					t.Fatal(err)
+				}
+				flags.apply(&cfg)
+
+				if got := model(&cfg); got.Model != tt.want || got.MaxTokens != tt.wantMaxTokens {
+					t.Errorf("Expected %s with %d max tokens, got %s with %d", tt.want, tt.wantMaxTokens, got.Model, got.MaxTokens)
+				}
+				// The other roles keep the model of the file.
+				for other, otherModel := range roles {
+					if other != role && otherModel(&cfg).Model != "file-"+other {
+						t.Errorf("Expected %s to keep file-%s, got %s", other, other, otherModel(&cfg).Model)
+					}
+				}
+			})
+		}
+	}
+}
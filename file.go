@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -144,7 +145,7 @@ func ReadFile(path string, offset int, length int) string {
 	return res
 }
 
-func WriteFile(path string, content string) string {
+func WriteFile(ctx context.Context, path string, content string) string {
 	path = Path(path)
 
 	// Reject if path points to a Go file
@@ -194,12 +195,13 @@ func WriteFile(path string, content string) string {
 		finalContent = content
 	}
 
-	err := os.WriteFile(path, []byte(finalContent), 0644)
+	// The write itself is not canceled, only the lint after it.
+	err := writeFileAtomic(path, []byte(finalContent), 0644)
 	if err != nil {
 		return fmt.Sprintf("Error writing to file: %v", err)
 	}
 
-	lint := Lint(path)
+	lint := Lint(ctx, path)
 
 	if strings.Contains(lint, "no Go files") {
 		lint = ""
@@ -219,7 +221,7 @@ func MkDir(path string) string {
 }
 
 func FetchWikiDocs() string {
-	wikiPath := "wiki"
+	wikiPath := Path("wiki")
 	files, err := os.ReadDir(wikiPath)
 	if err != nil {
 		return fmt.Sprintf("Error reading wiki directory: %v", err)
@@ -242,11 +244,11 @@ func FetchWikiDocs() string {
 	return strings.Join(docs, "\n\n---\n\n")
 }
 
-func SearchText(query string) string {
-	return searchTextRecursive(workingDirectory, query)
+func SearchText(ctx context.Context, query string) string {
+	return searchTextRecursive(ctx, workingDirectory, query)
 }
 
-func searchTextRecursive(dir string, query string) string {
+func searchTextRecursive(ctx context.Context, dir string, query string) string {
 	dir = Path(dir)
 
 	files, err := os.ReadDir(dir)
@@ -256,11 +258,14 @@ func searchTextRecursive(dir string, query string) string {
 
 	var results []string
 	for _, file := range files {
+		if ctx.Err() != nil {
+			break
+		}
 		filePath := filepath.Join(dir, file.Name())
 
 		if file.IsDir() {
 			// Recursively search subdirectories
-			subResults := searchTextRecursive(filePath, query)
+			subResults := searchTextRecursive(ctx, filePath, query)
 			if subResults != "No results found" {
 				results = append(results, subResults)
 			}
@@ -300,3 +305,27 @@ func Path(path string) string {
 	}
 	return path
 }
+
+// writeFileAtomic writes to a temporary file next to path and renames it, so an
+// interrupted write never leaves a truncated file behind.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
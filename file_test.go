@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"os"
 	"path/filepath"
 	"strings"
@@ -92,7 +93,7 @@ func TestWriteFile(t *testing.T) {
 	// Test writing to a new file
 	testFile := filepath.Join(tempDir, "test.txt")
 	content := "Hello, World!"
-	result := WriteFile(testFile, content)
+	result := WriteFile(context.Background(), testFile, content)
 	if !strings.Contains(result, "New content:") {
 		t.Errorf("Expected WriteFile to return success message, got: %s", result)
 	}
@@ -108,7 +109,7 @@ func TestWriteFile(t *testing.T) {
 
 	// Test writing with partial patch markers to an existing file
 	patchContent := "Updated content\n// ... existing code ..."
-	result = WriteFile(testFile, patchContent)
+	result = WriteFile(context.Background(), testFile, patchContent)
 	if !strings.Contains(result, "New content:") {
 		t.Errorf("Expected WriteFile with patch to return success message, got: %s", result)
 	}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"dev/tasks"
+)
+
+// A Gate is a check the whole project must pass before a run is finished.
+// Built-in gates only need a name: gofmt, vet, build, test and race.
+type Gate struct {
+	Name string `json:"name"`
+	// Command is run with sh -c in the working directory and must exit with 0.
+	Command string `json:"command,omitempty"`
+	// FailOnOutput also fails the gate when the command prints anything, like gofmt -l.
+	FailOnOutput bool `json:"fail_on_output,omitempty"`
+}
+
+// builtinGates are the Go gates, skipped when the working directory has no go.mod.
+var builtinGates = map[string]Gate{
+	"gofmt": {Name: "gofmt", Command: "gofmt -l .", FailOnOutput: true},
+	"vet":   {Name: "vet", Command: "go vet ./..."},
+	"build": {Name: "build", Command: "go build ./..."},
+	"test":  {Name: "test", Command: "go test ./..."},
+	"race":  {Name: "race", Command: "go test -race ./..."},
+}
+
+// GateTimeout bounds one gate.
+const GateTimeout = 20 * time.Minute
+
+// MaxGateOutputLines bounds the output of a failed gate copied into its task.
+const MaxGateOutputLines = 20
+
+type GateResult struct {
+	Gate     Gate          `json:"gate"`
+	Passed   bool          `json:"passed"`
+	Skipped  bool          `json:"skipped,omitempty"`
+	Output   string        `json:"output,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+func resolveGate(gate Gate) (Gate, error) {
+	if gate.Command != "" {
+		return gate, nil
+	}
+	builtin, ok := builtinGates[gate.Name]
+	if !ok {
+		return gate, fmt.Errorf("gate %q has no command and is not a built-in gate", gate.Name)
+	}
+	return builtin, nil
+}
+
+// RunGates runs the configured gates in order.
+func RunGates(ctx context.Context) ([]GateResult, error) {
+	goProject := modulePath() != ""
+	var results []GateResult
+	for _, gate := range config.Gates {
+		gate, err := resolveGate(gate)
+		if err != nil {
+			return nil, err
+		}
+		if _, builtin := builtinGates[gate.Name]; builtin && !goProject {
+			results = append(results, GateResult{Gate: gate, Passed: true, Skipped: true})
+			continue
+		}
+		result := runGate(ctx, gate)
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		log.Printf("Gate %s passed: %t (%s)", gate.Name, result.Passed, result.Duration.Round(time.Millisecond))
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func runGate(ctx context.Context, gate Gate) GateResult {
+	ctx, cancel := context.WithTimeout(ctx, GateTimeout)
+	defer cancel()
+
+	start := time.Now()
+	command := exec.CommandContext(ctx, "sh", "-c", gate.Command)
+	command.Dir = workingDirectory
+	output, err := command.CombinedOutput()
+	result := GateResult{Gate: gate, Output: strings.TrimSpace(string(output)), Duration: time.Since(start)}
+	result.Passed = err == nil && !(gate.FailOnOutput && result.Output != "")
+	recordCommand("gate", gate.Command, result.Passed, result.Output, err, start)
+	if err != nil && result.Output == "" {
+		result.Output = err.Error()
+	}
+	return result
+}
+
+// acceptCommand returns the acceptance check that passes when the gate passes.
+func (g Gate) acceptCommand() string {
+	if g.FailOnOutput {
+		return fmt.Sprintf(`accept: test -z "$(%s)"`, g.Command)
+	}
+	return "accept: " + g.Command
+}
+
+// addGateTasks adds a task for every failed gate and returns how many were added.
+// A gate whose task was already blocked is not retried.
+func addGateTasks(results []GateResult) (int, error) {
+	tasksMu.Lock()
+	defer tasksMu.Unlock()
+
+	doc, err := LoadTasks()
+	if err != nil {
+		return 0, fmt.Errorf("reading TASKS.md: %w", err)
+	}
+	added := 0
+	for _, result := range results {
+		if result.Passed {
+			continue
+		}
+		title := fmt.Sprintf("Fix the %s gate", result.Gate.Name)
+		if blocked(doc, title) {
+			log.Printf("Gate %s still fails, its task was blocked", result.Gate.Name)
+			continue
+		}
+		notes := []string{fmt.Sprintf("`%s` failed:", result.Gate.Command)}
+		lines := strings.Split(result.Output, "\n")
+		if len(lines) > MaxGateOutputLines {
+			lines = append(lines[:MaxGateOutputLines], "...")
+		}
+		for _, line := range lines {
+			notes = append(notes, "> "+line)
+		}
+		notes = append(notes, result.Gate.acceptCommand())
+		if _, err := doc.Add(title, 0, notes...); err != nil {
+			return added, err
+		}
+		added++
+	}
+	if added == 0 {
+		return 0, nil
+	}
+	return added, doc.Save(TasksPath())
+}
+
+func gatesPassed(results []GateResult) bool {
+	for _, result := range results {
+		if !result.Passed {
+			return false
+		}
+	}
+	return true
+}
+
+func blocked(doc *tasks.Document, title string) bool {
+	for _, task := range doc.All() {
+		if task.Title == title && task.State == tasks.Blocked {
+			return true
+		}
+	}
+	return false
+}
+
+func GateReport(results []GateResult) string {
+	var b strings.Builder
+	b.WriteString("# Gate report\n\n")
+	fmt.Fprintf(&b, "%s\n\n", time.Now().Format(time.RFC3339))
+	b.WriteString("| Gate | Command | Result | Duration |\n")
+	b.WriteString("|------|---------|--------|----------|\n")
+	for _, result := range results {
+		status := "failed"
+		switch {
+		case result.Skipped:
+			status = "skipped (no go.mod)"
+		case result.Passed:
+			status = "passed"
+		}
+		fmt.Fprintf(&b, "| %s | `%s` | %s | %s |\n", result.Gate.Name, result.Gate.Command, status, result.Duration.Round(time.Millisecond))
+	}
+	for _, result := range results {
+		if result.Passed || result.Skipped {
+			continue
+		}
+		fmt.Fprintf(&b, "\n## %s\n\n```\n%s\n```\n", result.Gate.Name, result.Output)
+	}
+	return b.String()
+}
+
+func WriteGateReport(results []GateResult) error {
+	path := filepath.Join(workingDirectory, ".dev", "report.md")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(GateReport(results)), 0644)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGates(t *testing.T) {
+	workingDirectory = t.TempDir()
+	config = DefaultConfig()
+	config.Gates = append(config.Gates,
+		Gate{Name: "ok", Command: "true"},
+		Gate{Name: "broken", Command: "echo broken; exit 1"},
+		Gate{Name: "clean", Command: "echo dirty.go", FailOnOutput: true},
+	)
+	defer func() { config = Config{} }()
+
+	results, err := RunGates(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Without a go.mod the four Go gates are skipped.
+	if len(results) != 7 || !results[0].Skipped || !results[3].Skipped {
+		t.Fatalf("Expected the Go gates to be skipped, got %+v", results)
+	}
+	if !results[4].Passed || results[5].Passed || results[6].Passed || results[5].Output != "broken" {
+		t.Errorf("Unexpected results: %+v", results[4:])
+	}
+
+	if err := WriteGateReport(results); err != nil {
+		t.Fatal(err)
+	}
+	report, _ := os.ReadFile(filepath.Join(workingDirectory, ".dev", "report.md"))
+	for _, want := range []string{"| vet | `go vet ./...` | skipped (no go.mod) |", "| ok | `true` | passed |", "## broken\n\n```\nbroken\n```", "## clean"} {
+		if !strings.Contains(string(report), want) {
+			t.Errorf("Expected %q in the report:\n%s", want, report)
+		}
+	}
+
+	if added, err := addGateTasks(results); err != nil || added != 2 {
+		t.Fatalf("Expected 2 gate tasks, got %d (%v)", added, err)
+	}
+	doc, _ := LoadTasks()
+	clean := doc.Find(2)
+	checks, _ := TaskChecks(clean)
+	if clean.Title != "Fix the clean gate" || len(checks) != 1 || checks[0].Command != `test -z "$(echo dirty.go)"` {
+		t.Errorf("Unexpected gate task: %+v", clean)
+	}
+	if RunChecks(context.Background(), checks) == "" {
+		t.Errorf("Expected the acceptance check of the gate to fail like the gate")
+	}
+
+	// A gate whose task was blocked does not come back.
+	doc.Block(1, "gave up")
+	doc.Save(TasksPath())
+	if added, _ := addGateTasks(results); added != 1 {
+		t.Errorf("Expected only the clean gate to be added again, got %d", added)
+	}
+
+	config.Gates = []Gate{{Name: "lint"}}
+	if _, err := RunGates(context.Background()); err == nil {
+		t.Errorf("Expected an error for an unknown gate without a command")
+	}
+}
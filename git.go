@@ -0,0 +1,371 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Repo runs git in a directory, the working directory unless told otherwise.
+type Repo struct {
+	Dir string
+	// GitDir is a repository kept apart from the directory, like the snapshots of a
+	// directory that is not a git repository.
+	GitDir string
+	// Index is an index file used instead of the one of the repository.
+	Index string
+}
+
+func WorkingRepo() Repo {
+	return Repo{Dir: workingDirectory}
+}
+
+// GitError is returned when git exits with an error, with what it printed.
+type GitError struct {
+	Args   []string
+	Output string
+	Err    error
+}
+
+func (e *GitError) Error() string {
+	return fmt.Sprintf("git %s: %s: %s", strings.Join(e.Args, " "), e.Err, strings.TrimSpace(e.Output))
+}
+
+func (e *GitError) Unwrap() error {
+	return e.Err
+}
+
+func (r Repo) git(ctx context.Context, args ...string) (string, error) {
+	var global []string
+	if r.GitDir != "" {
+		global = []string{"--git-dir=" + r.GitDir, "--work-tree=" + r.Dir}
+	}
+	command := exec.CommandContext(ctx, "git", append(global, args...)...)
+	command.Dir = r.Dir
+	if r.Index != "" {
+		command.Env = append(os.Environ(), "GIT_INDEX_FILE="+r.Index)
+	}
+	var stdout, stderr bytes.Buffer
+	command.Stdout = &stdout
+	command.Stderr = &stderr
+	if err := command.Run(); err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		return stdout.String(), &GitError{Args: args, Output: stderr.String(), Err: err}
+	}
+	return stdout.String(), nil
+}
+
+// IsRepo reports whether the directory is inside a git repository.
+func (r Repo) IsRepo(ctx context.Context) bool {
+	_, err := r.git(ctx, "rev-parse", "--git-dir")
+	return err == nil
+}
+
+// Head returns the commit checked out, or an error when there is none yet.
+func (r Repo) Head(ctx context.Context) (string, error) {
+	out, err := r.git(ctx, "rev-parse", "--verify", "-q", "HEAD")
+	return strings.TrimSpace(out), err
+}
+
+func (r Repo) Status(ctx context.Context) (string, error) {
+	return r.git(ctx, "status", "--short", "--branch")
+}
+
+type DiffOptions struct {
+	// Staged diffs the index against HEAD instead of the working tree against the index.
+	Staged bool
+	// Base diffs the working tree against a commit, HEAD for every change since the last commit.
+	Base  string
+	Stat  bool
+	Paths []string
+}
+
+func (r Repo) Diff(ctx context.Context, options DiffOptions) (string, error) {
+	args := []string{"diff", "--no-color"}
+	if options.Staged {
+		args = append(args, "--cached")
+	}
+	if options.Stat {
+		args = append(args, "--stat")
+	}
+	if options.Base != "" {
+		args = append(args, options.Base)
+	}
+	if len(options.Paths) > 0 {
+		args = append(args, "--")
+		args = append(args, options.Paths...)
+	}
+	return r.git(ctx, args...)
+}
+
+func (r Repo) Log(ctx context.Context, n int, paths ...string) (string, error) {
+	if n <= 0 {
+		n = 10
+	}
+	args := []string{"log", "--no-color", fmt.Sprintf("-n%d", n), "--format=%h %ad %an%n    %s", "--date=short"}
+	if len(paths) > 0 {
+		args = append(args, "--")
+		args = append(args, paths...)
+	}
+	return r.git(ctx, args...)
+}
+
+func (r Repo) Show(ctx context.Context, rev string) (string, error) {
+	return r.git(ctx, "show", "--no-color", rev)
+}
+
+// ChangedFiles returns the files changed since the last commit and the untracked ones,
+// relative to the directory. Deleted files are left out.
+func (r Repo) ChangedFiles(ctx context.Context) ([]string, error) {
+	changed, err := r.git(ctx, "diff", "--name-only", "--relative", "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	untracked, err := r.git(ctx, "ls-files", "--others", "--exclude-standard")
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, file := range strings.Split(changed+untracked, "\n") {
+		if file == "" || contains(files, file) {
+			continue
+		}
+		if _, err := os.Stat(r.path(file)); err != nil {
+			continue
+		}
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+var hunkHeader = regexp.MustCompile(`^@@ -\S+ \+(\d+)(?:,(\d+))? @@`)
+
+// AddedLines returns the lines of file added since the last commit, from git diff -U0.
+// It returns nil, meaning every line, for an untracked file.
+func (r Repo) AddedLines(ctx context.Context, file string) (map[int]bool, error) {
+	diff, err := r.git(ctx, "diff", "-U0", "--relative", "HEAD", "--", file)
+	if err != nil {
+		return nil, err
+	}
+	if diff == "" {
+		if _, err := r.git(ctx, "ls-files", "--error-unmatch", "--", file); err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, nil
+		}
+	}
+	return hunkLines(diff), nil
+}
+
+// hunkLines returns the added lines of the hunks of a diff -U0.
+func hunkLines(diff string) map[int]bool {
+	added := map[int]bool{}
+	for _, line := range strings.Split(diff, "\n") {
+		match := hunkHeader.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		start, _ := strconv.Atoi(match[1])
+		count := 1
+		if match[2] != "" {
+			count, _ = strconv.Atoi(match[2])
+		}
+		for i := start; i < start+count; i++ {
+			added[i] = true
+		}
+	}
+	return added
+}
+
+// ErrNothingToCommit is returned by Commit when there are no changes.
+var ErrNothingToCommit = errors.New("nothing to commit")
+
+// Commit stages paths, or every change when there are none, and commits them. It
+// returns the new commit.
+func (r Repo) Commit(ctx context.Context, message string, paths ...string) (string, error) {
+	if err := r.Stage(ctx, paths...); err != nil {
+		return "", err
+	}
+	if _, err := r.git(ctx, "diff", "--cached", "--quiet"); err == nil {
+		return "", ErrNothingToCommit
+	}
+	if _, err := r.git(ctx, append(r.identity(ctx), "commit", "-q", "-m", message)...); err != nil {
+		return "", err
+	}
+	return r.Head(ctx)
+}
+
+// identity commits as dev when no identity is configured, rather than failing.
+func (r Repo) identity(ctx context.Context) []string {
+	if out, _ := r.git(ctx, "config", "user.email"); strings.TrimSpace(out) == "" && os.Getenv("GIT_AUTHOR_EMAIL") == "" {
+		return []string{"-c", "user.name=dev", "-c", "user.email=dev@localhost"}
+	}
+	return nil
+}
+
+// CommitTree makes a commit of tree with the given parents without moving any branch.
+func (r Repo) CommitTree(ctx context.Context, tree, message string, parents ...string) (string, error) {
+	args := append(r.identity(ctx), "commit-tree", tree, "-m", message)
+	for _, parent := range parents {
+		args = append(args, "-p", parent)
+	}
+	out, err := r.git(ctx, args...)
+	return strings.TrimSpace(out), err
+}
+
+// MergeTree merges the commits a and b without touching the directory or the index.
+// It returns the merged tree and the files that conflict, if any; the tree then has
+// conflict markers.
+func (r Repo) MergeTree(ctx context.Context, a, b string) (string, []string, error) {
+	out, err := r.git(ctx, "merge-tree", "--write-tree", "--name-only", "--no-messages", a, b)
+	if err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) || exitErr.ExitCode() != 1 {
+			return "", nil, err
+		}
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	var conflicts []string
+	for _, file := range lines[1:] {
+		if file != "" && !contains(conflicts, file) {
+			conflicts = append(conflicts, file)
+		}
+	}
+	return lines[0], conflicts, nil
+}
+
+// AddWorktree checks out commit in a new worktree at dir, on branch.
+func (r Repo) AddWorktree(ctx context.Context, dir, branch, commit string) error {
+	_, err := r.git(ctx, "worktree", "add", "-q", "-f", "-B", branch, dir, commit)
+	return err
+}
+
+// RemoveWorktree removes the worktree at dir and its branch.
+func (r Repo) RemoveWorktree(ctx context.Context, dir, branch string) error {
+	if _, err := r.git(ctx, "worktree", "remove", "--force", dir); err != nil {
+		return err
+	}
+	_, err := r.git(ctx, "branch", "-D", branch)
+	return err
+}
+
+// Stage adds paths, or every change when there are none, to the index.
+func (r Repo) Stage(ctx context.Context, paths ...string) error {
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	_, err := r.git(ctx, append([]string{"add", "-A", "--"}, paths...)...)
+	return err
+}
+
+// WriteTree stages paths, or every change, on top of HEAD and writes the index to a
+// tree object, which it returns. Use it with a separate Index to leave the one of the
+// repository alone.
+func (r Repo) WriteTree(ctx context.Context, paths ...string) (string, error) {
+	if _, err := r.Head(ctx); err == nil {
+		if _, err := r.git(ctx, "read-tree", "HEAD"); err != nil {
+			return "", err
+		}
+	}
+	if err := r.Stage(ctx, paths...); err != nil {
+		return "", err
+	}
+	out, err := r.git(ctx, "write-tree")
+	return strings.TrimSpace(out), err
+}
+
+// SwitchTree updates the files of the directory from the tree from, which the index
+// must hold, to the tree to. Files that are not in to are removed.
+func (r Repo) SwitchTree(ctx context.Context, from, to string) error {
+	_, err := r.git(ctx, "read-tree", "-m", "-u", from, to)
+	return err
+}
+
+// Stash saves the uncommitted changes, untracked files included, and cleans the tree.
+func (r Repo) Stash(ctx context.Context, message string) error {
+	_, err := r.git(ctx, "stash", "push", "--include-untracked", "-m", message)
+	return err
+}
+
+func (r Repo) StashPop(ctx context.Context) error {
+	_, err := r.git(ctx, "stash", "pop")
+	return err
+}
+
+func (r Repo) path(file string) string {
+	return filepath.Join(r.Dir, file)
+}
+
+// changesSummary lists the changes of the working directory since the run started, or
+// since the last commit without a start snapshot, for the judge.
+func changesSummary(ctx context.Context) string {
+	repo := WorkingRepo()
+	var summary []string
+	if stat, started, err := DiffSinceStart(ctx, []string{"--stat"}); started {
+		if err == nil {
+			summary = append(summary, stat)
+		}
+	} else if _, err := repo.Head(ctx); err == nil {
+		if stat, err := repo.Diff(ctx, DiffOptions{Base: "HEAD", Stat: true}); err == nil {
+			summary = append(summary, stat)
+		}
+	}
+	if !repo.IsRepo(ctx) {
+		if len(summary) == 0 {
+			return "(not a git repository)"
+		}
+		return strings.TrimSpace(strings.Join(summary, "\n"))
+	}
+	if status, err := repo.Status(ctx); err == nil {
+		summary = append(summary, status)
+	}
+	return strings.TrimSpace(strings.Join(summary, "\n"))
+}
+
+// GitStatus, GitDiff and GitLog are the git tools of the agent.
+func GitStatus(ctx context.Context) string {
+	out, err := WorkingRepo().Status(ctx)
+	if err != nil {
+		return fmt.Sprintf("Error: %s", err)
+	}
+	return out
+}
+
+func GitDiff(ctx context.Context, staged bool, path string) string {
+	options := DiffOptions{Staged: staged}
+	if path != "" {
+		options.Paths = []string{path}
+	}
+	out, err := WorkingRepo().Diff(ctx, options)
+	if err != nil {
+		return fmt.Sprintf("Error: %s", err)
+	}
+	if out == "" {
+		return "No changes"
+	}
+	return out
+}
+
+func GitLog(ctx context.Context, n int, path string) string {
+	var paths []string
+	if path != "" {
+		paths = []string{path}
+	}
+	out, err := WorkingRepo().Log(ctx, n, paths...)
+	if err != nil {
+		return fmt.Sprintf("Error: %s", err)
+	}
+	return out
+}
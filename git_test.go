@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// initRepo makes the working directory a new git repository with one commit.
+func initRepo(t *testing.T) Repo {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	for _, name := range []string{"GIT_AUTHOR_NAME", "GIT_COMMITTER_NAME"} {
+		t.Setenv(name, "test")
+	}
+	for _, name := range []string{"GIT_AUTHOR_EMAIL", "GIT_COMMITTER_EMAIL"} {
+		t.Setenv(name, "test@example.com")
+	}
+	repo := WorkingRepo()
+	ctx := context.Background()
+	if _, err := repo.git(ctx, "init", "-q"); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(workingDirectory, "README.md"), []byte("# test\n"), 0644)
+	if _, err := repo.Commit(ctx, "init"); err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
+
+func TestRepo(t *testing.T) {
+	workingDirectory = t.TempDir()
+	ctx := context.Background()
+	if WorkingRepo().IsRepo(ctx) {
+		t.Fatalf("Expected a new directory not to be a repository")
+	}
+	repo := initRepo(t)
+
+	if _, err := repo.Commit(ctx, "nothing"); !errors.Is(err, ErrNothingToCommit) {
+		t.Errorf("Expected ErrNothingToCommit, got %v", err)
+	}
+
+	os.WriteFile(filepath.Join(workingDirectory, "README.md"), []byte("# test\nmore\n"), 0644)
+	os.WriteFile(filepath.Join(workingDirectory, "new.txt"), []byte("new\n"), 0644)
+	status, _ := repo.Status(ctx)
+	if !strings.Contains(status, " M README.md") || !strings.Contains(status, "?? new.txt") {
+		t.Errorf("Unexpected status:\n%s", status)
+	}
+	files, _ := repo.ChangedFiles(ctx)
+	if strings.Join(files, ",") != "README.md,new.txt" {
+		t.Errorf("Unexpected changed files: %q", files)
+	}
+	if diff, _ := repo.Diff(ctx, DiffOptions{Paths: []string{"README.md"}}); !strings.Contains(diff, "+more") {
+		t.Errorf("Unexpected diff:\n%s", diff)
+	}
+	if diff, _ := repo.Diff(ctx, DiffOptions{Staged: true}); diff != "" {
+		t.Errorf("Expected nothing staged, got:\n%s", diff)
+	}
+
+	if err := repo.Stash(ctx, "wip"); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := repo.ChangedFiles(ctx); len(files) != 0 {
+		t.Errorf("Expected a clean tree after stashing, got %q", files)
+	}
+	if err := repo.StashPop(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	head, err := repo.Commit(ctx, "Add new.txt", "new.txt")
+	if err != nil || len(head) != 40 {
+		t.Fatalf("Commit failed: %q %v", head, err)
+	}
+	if show, _ := repo.Show(ctx, head); !strings.Contains(show, "Add new.txt") || strings.Contains(show, "README.md") {
+		t.Errorf("Expected only new.txt in the commit:\n%s", show)
+	}
+	if log, _ := repo.Log(ctx, 1); !strings.Contains(log, "Add new.txt") || strings.Contains(log, "init") {
+		t.Errorf("Unexpected log:\n%s", log)
+	}
+
+	var gitErr *GitError
+	if _, err := repo.Show(ctx, "no-such-rev"); !errors.As(err, &gitErr) || !strings.Contains(gitErr.Output, "no-such-rev") {
+		t.Errorf("Expected a GitError, got %v", err)
+	}
+
+	tool := func(name string, arguments map[string]any) string {
+		return ToolCall(ctx, openai.ToolCall{Function: call(name, arguments)})
+	}
+	if out := tool("git_status", nil); !strings.Contains(out, " M README.md") {
+		t.Errorf("Unexpected git_status:\n%s", out)
+	}
+	if out := tool("git_diff", map[string]any{"path": "new.txt"}); out != "No changes" {
+		t.Errorf("Unexpected git_diff:\n%s", out)
+	}
+	if out := tool("git_log", map[string]any{"count": 5, "path": "README.md"}); !strings.Contains(out, "init") || strings.Contains(out, "Add new.txt") {
+		t.Errorf("Unexpected git_log:\n%s", out)
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/sashabaranov/go-openai/jsonschema"
+)
+
+// Verdict is the structured answer of the judge to a yes/no question.
+type Verdict struct {
+	Answer     bool     `json:"answer"`
+	Rationale  string   `json:"rationale"`
+	Confidence float64  `json:"confidence"`
+	Unmet      []string `json:"unmet,omitempty"`
+	// Votes for and against when the verdict is a majority vote over several samples.
+	Yes int `json:"yes"`
+	No  int `json:"no"`
+}
+
+func (v Verdict) String() string {
+	answer := "no"
+	if v.Answer {
+		answer = "yes"
+	}
+	return fmt.Sprintf("%s (confidence %.2f, votes %d/%d): %s", answer, v.Confidence, v.Yes, v.Yes+v.No, v.Rationale)
+}
+
+// ErrNoVerdict is returned when the judge answered without calling the verdict tool.
+var ErrNoVerdict = errors.New("the judge did not give a verdict")
+
+const judgePrompt = `You are a strict reviewer. Answer the question by calling the verdict tool exactly once.
+Answer "yes" only when you are sure. Give a short rationale, your confidence between 0 and 1,
+and list every item that is not met yet, so that it can be fixed.`
+
+func verdictTool() openai.Tool {
+	return openai.Tool{
+		Type: openai.ToolTypeFunction,
+		Function: &openai.FunctionDefinition{
+			Name:        "verdict",
+			Description: "Give the verdict on the question",
+			Parameters: jsonschema.Definition{
+				Type: jsonschema.Object,
+				Properties: map[string]jsonschema.Definition{
+					"verdict": {
+						Type:        jsonschema.String,
+						Enum:        []string{"yes", "no"},
+						Description: "The answer to the question",
+					},
+					"rationale": {
+						Type:        jsonschema.String,
+						Description: "Why, in one or two sentences",
+					},
+					"confidence": {
+						Type:        jsonschema.Number,
+						Description: "How sure you are, between 0 and 1",
+					},
+					"unmet": {
+						Type:        jsonschema.Array,
+						Description: "The items that are not met yet, empty when the answer is yes",
+						Items:       &jsonschema.Definition{Type: jsonschema.String},
+					},
+				},
+				Required: []string{"verdict", "rationale", "confidence", "unmet"},
+			},
+		},
+	}
+}
+
+// Judge asks the judge model a yes/no question. With config.JudgeSamples > 1 the
+// question is sampled several times and the majority wins; a tie answers no.
+func Judge(ctx context.Context, question string) (Verdict, error) {
+	samples := config.JudgeSamples
+	if samples < 1 {
+		samples = 1
+	}
+
+	var yes, no []Verdict
+	var lastErr error
+	for i := 0; i < samples; i++ {
+		verdict, err := judgeOnce(ctx, question)
+		if err != nil {
+			if ctx.Err() != nil {
+				return Verdict{}, ctx.Err()
+			}
+			var budgetErr *BudgetError
+			if errors.As(err, &budgetErr) {
+				return Verdict{}, err
+			}
+			log.Printf("Judge sample %d/%d failed: %s", i+1, samples, err)
+			lastErr = err
+			continue
+		}
+		if verdict.Answer {
+			yes = append(yes, verdict)
+		} else {
+			no = append(no, verdict)
+		}
+	}
+	if len(yes)+len(no) == 0 {
+		return Verdict{}, fmt.Errorf("judging %q: %w", firstLine(question), lastErr)
+	}
+
+	majority := no
+	if len(yes) > len(no) {
+		majority = yes
+	}
+	verdict := Verdict{Answer: len(yes) > len(no), Yes: len(yes), No: len(no)}
+	var best float64 = -1
+	for _, v := range majority {
+		verdict.Confidence += v.Confidence / float64(len(majority))
+		if v.Confidence > best {
+			best = v.Confidence
+			verdict.Rationale = v.Rationale
+		}
+	}
+	// The unmet items of every no vote are worth fixing, even when outvoted.
+	for _, v := range no {
+		for _, item := range v.Unmet {
+			if !contains(verdict.Unmet, item) {
+				verdict.Unmet = append(verdict.Unmet, item)
+			}
+		}
+	}
+	if verdict.Answer {
+		verdict.Unmet = nil
+	}
+	log.Printf("Verdict: %s", verdict)
+	return verdict, nil
+}
+
+func judgeOnce(ctx context.Context, question string) (Verdict, error) {
+	request := openai.ChatCompletionRequest{
+		Model: config.Models.Judge.Model,
+		Messages: []openai.ChatCompletionMessage{
+			{
+				Role:    openai.ChatMessageRoleSystem,
+				Content: judgePrompt,
+			},
+			{
+				Role:    openai.ChatMessageRoleUser,
+				Content: question,
+			},
+		},
+		Temperature: requestTemperature(config.Models.Judge.Temperature),
+		MaxTokens:   config.Models.Judge.MaxTokens,
+		Tools:       []openai.Tool{verdictTool()},
+	}
+	if provider.Capabilities().ToolChoice {
+		request.ToolChoice = openai.ToolChoice{Type: openai.ToolTypeFunction, Function: openai.ToolFunction{Name: "verdict"}}
+	}
+	response, err := createChatCompletion(ctx, request)
+	if err != nil {
+		return Verdict{}, err
+	}
+	if len(response.Choices) == 0 {
+		return Verdict{}, ErrNoVerdict
+	}
+	for _, toolCall := range response.Choices[0].Message.ToolCalls {
+		if toolCall.Function.Name == "verdict" {
+			return parseVerdict(toolCall.Function.Arguments)
+		}
+	}
+	return Verdict{}, ErrNoVerdict
+}
+
+func parseVerdict(arguments string) (Verdict, error) {
+	var raw struct {
+		Verdict    string   `json:"verdict"`
+		Rationale  string   `json:"rationale"`
+		Confidence float64  `json:"confidence"`
+		Unmet      []string `json:"unmet"`
+	}
+	if err := json.Unmarshal([]byte(arguments), &raw); err != nil {
+		return Verdict{}, fmt.Errorf("parsing verdict: %w", err)
+	}
+	verdict := Verdict{Rationale: raw.Rationale, Confidence: raw.Confidence, Unmet: raw.Unmet}
+	switch strings.ToLower(strings.TrimSpace(raw.Verdict)) {
+	case "yes":
+		verdict.Answer = true
+		verdict.Yes = 1
+	case "no":
+		verdict.No = 1
+	default:
+		return Verdict{}, fmt.Errorf("%w: unknown answer %q", ErrNoVerdict, raw.Verdict)
+	}
+	verdict.Confidence = min(max(verdict.Confidence, 0), 1)
+	return verdict, nil
+}
+
+func firstLine(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.Index(s, "\n"); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestJudge(t *testing.T) {
+	const model = "test-model"
+	replay := NewReplayProvider(&Cassette{Interactions: []Interaction{
+		verdictInteraction(model, "no", "hello.txt is empty"),
+		verdictInteraction(model, "yes"),
+		verdictInteraction(model, "no", "hello.txt is empty", "no test"),
+		// A sample without a verdict does not count.
+		textInteraction(model, "I think so."),
+		verdictInteraction(model, "yes"),
+	}})
+	provider = replay
+	config = DefaultConfig()
+	config.SetModel(model)
+	config.Retry = RetryConfig{MaxAttempts: 1}
+	config.JudgeSamples = 3
+	usage = &UsageTracker{}
+	defer func() { config = Config{}; usage = &UsageTracker{} }()
+
+	verdict, err := Judge(context.Background(), "Is hello.txt done?")
+	if err != nil {
+		t.Fatalf("Judge failed: %v", err)
+	}
+	if verdict.Answer || verdict.Yes != 1 || verdict.No != 2 {
+		t.Errorf("Expected a 2 to 1 no, got %+v", verdict)
+	}
+	if strings.Join(verdict.Unmet, ",") != "hello.txt is empty,no test" {
+		t.Errorf("Expected the unmet items of the no votes, got %q", verdict.Unmet)
+	}
+	request := replay.Received[0]
+	if choice, ok := request.ToolChoice.(openai.ToolChoice); !ok || choice.Function.Name != "verdict" {
+		t.Errorf("Expected the verdict tool to be forced, got %#v", request.ToolChoice)
+	}
+	note := unmetItems(&verdict)
+	if !strings.Contains(note, "- hello.txt is empty") || !strings.Contains(note, "- no test") {
+		t.Errorf("Expected the unmet items in the task note, got %q", note)
+	}
+
+	config.JudgeSamples = 2
+	verdict, err = Judge(context.Background(), "Is hello.txt done?")
+	if err != nil {
+		t.Fatalf("Judge failed: %v", err)
+	}
+	if !verdict.Answer || verdict.Yes != 1 || verdict.No != 0 || unmetItems(&verdict) != "" {
+		t.Errorf("Expected a yes from the only valid sample, got %+v", verdict)
+	}
+
+	if _, err := Judge(context.Background(), "Is hello.txt done?"); err == nil {
+		t.Errorf("Expected an error once the judge cannot answer")
+	}
+}
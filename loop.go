@@ -0,0 +1,205 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// Actions taken when the agent is stuck.
+const (
+	LoopNudge    = "nudge"    // tell the model it is going in circles
+	LoopEscalate = "escalate" // switch to Models.Escalation for the rest of the task
+	LoopBlock    = "block"    // mark the task as blocked and move on
+	LoopAbort    = "abort"    // stop the run
+)
+
+// Returned by converse when the current task was blocked instead of finished.
+const TaskBlocked = "task_blocked"
+
+// MaxNudges is how many times the model is nudged in one conversation before the task is blocked.
+const MaxNudges = 2
+
+type LoopConfig struct {
+	// Identical tool calls (same tool and arguments) allowed in one conversation.
+	MaxRepeatedToolCalls int `json:"max_repeated_tool_calls,omitempty"`
+	// Iterations in a row that can leave TASKS.md unchanged.
+	MaxUnchangedIterations int `json:"max_unchanged_iterations,omitempty"`
+	// Model turns allowed for one task, and for the whole run.
+	MaxStepsPerTask int `json:"max_steps_per_task,omitempty"`
+	MaxStepsPerRun  int `json:"max_steps_per_run,omitempty"`
+	// Attempts allowed for one task before it is blocked.
+	MaxTaskAttempts int `json:"max_task_attempts,omitempty"`
+	// Action is one of nudge, escalate, block or abort.
+	Action string `json:"action,omitempty"`
+}
+
+// LoopError is returned when the agent is going in circles.
+// Hard loop errors (the run step limit) always stop the run.
+type LoopError struct {
+	Reason string
+	Hard   bool
+}
+
+func (e *LoopError) Error() string {
+	return fmt.Sprintf("loop detected: %s", e.Reason)
+}
+
+// LoopDetector keeps the counters used to detect loops and stalls. It is saved in the session.
+type LoopDetector struct {
+	ToolCalls map[string]int `json:"tool_calls,omitempty"`
+	TaskSteps int            `json:"task_steps"`
+	RunSteps  int            `json:"run_steps"`
+	Nudges    int            `json:"nudges"`
+	Escalated bool           `json:"escalated"`
+
+	TasksHash           string `json:"tasks_hash,omitempty"`
+	UnchangedIterations int    `json:"unchanged_iterations"`
+	// Stalls counts the stalls detected since TASKS.md last changed.
+	Stalls int `json:"stalls"`
+}
+
+var loop = &LoopDetector{}
+
+// ResetConversation clears the counters that only apply to one conversation.
+func (d *LoopDetector) ResetConversation() {
+	d.ToolCalls = nil
+	d.TaskSteps = 0
+	d.Nudges = 0
+	d.Escalated = false
+}
+
+// ObserveTurn counts one assistant turn and its tool calls.
+func (d *LoopDetector) ObserveTurn(message openai.ChatCompletionMessage) error {
+	d.TaskSteps++
+	d.RunSteps++
+	if config.Loop.MaxStepsPerRun > 0 && d.RunSteps > config.Loop.MaxStepsPerRun {
+		return &LoopError{Reason: fmt.Sprintf("the run took more than %d steps", config.Loop.MaxStepsPerRun), Hard: true}
+	}
+	if config.Loop.MaxStepsPerTask > 0 && d.TaskSteps > config.Loop.MaxStepsPerTask {
+		return &LoopError{Reason: fmt.Sprintf("the task took more than %d steps", config.Loop.MaxStepsPerTask)}
+	}
+
+	if d.ToolCalls == nil {
+		d.ToolCalls = map[string]int{}
+	}
+	for _, toolCall := range message.ToolCalls {
+		signature := toolCall.Function.Name + " " + toolCall.Function.Arguments
+		d.ToolCalls[signature]++
+		if max := config.Loop.MaxRepeatedToolCalls; max > 0 && d.ToolCalls[signature] > max {
+			return &LoopError{Reason: fmt.Sprintf("%s was called %d times with the same arguments", toolCall.Function.Name, d.ToolCalls[signature])}
+		}
+	}
+	return nil
+}
+
+// ObserveTasks is called with TASKS.md at the start of every iteration and reports
+// when it did not change for too many iterations.
+func (d *LoopDetector) ObserveTasks(tasks string) error {
+	sum := sha256.Sum256([]byte(tasks))
+	hash := hex.EncodeToString(sum[:])
+	if hash != d.TasksHash {
+		d.TasksHash = hash
+		d.UnchangedIterations = 0
+		d.Stalls = 0
+		return nil
+	}
+	d.UnchangedIterations++
+	if max := config.Loop.MaxUnchangedIterations; max > 0 && d.UnchangedIterations >= max {
+		d.UnchangedIterations = 0
+		d.Stalls++
+		return &LoopError{Reason: fmt.Sprintf("TASKS.md did not change in %d iterations", max)}
+	}
+	return nil
+}
+
+// loopAction returns the action to take for err, downgrading to block when the
+// configured action was already tried in this conversation.
+func loopAction(err *LoopError) string {
+	if err.Hard {
+		return LoopAbort
+	}
+	action := config.Loop.Action
+	if action == LoopEscalate && (loop.Escalated || config.Models.Escalation.Model == "") {
+		action = LoopNudge
+	}
+	if action == LoopNudge && loop.Nudges >= MaxNudges {
+		action = LoopBlock
+	}
+	if action == "" {
+		action = LoopNudge
+	}
+	return action
+}
+
+// recoverFromLoop applies the loop action inside a running conversation. It returns the
+// error when the run must stop, and blocked when the conversation must end.
+func recoverFromLoop(err *LoopError) (blocked bool, stop error) {
+	action := loopAction(err)
+	log.Printf("%s, action: %s", err, action)
+
+	switch action {
+	case LoopAbort:
+		return false, err
+	case LoopBlock:
+		if session != nil && session.Phase == PhaseTask {
+			if err := blockCurrentTask(err.Reason); err != nil {
+				return false, err
+			}
+		}
+		return true, nil
+	case LoopEscalate:
+		loop.Escalated = true
+		log.Printf("Switching to %s", config.Models.Escalation.Model)
+	}
+
+	loop.Nudges++
+	loop.ToolCalls = nil
+	loop.TaskSteps = 0
+	messages = append(messages, openai.ChatCompletionMessage{
+		Role:    openai.ChatMessageRoleUser,
+		Content: nudge(err.Reason),
+	})
+	saveSession()
+	return false, nil
+}
+
+func nudge(reason string) string {
+	return fmt.Sprintf(`It looks like you are going in circles: %s.
+Step back and try a different approach. If the task cannot be done, stop and explain why.`, reason)
+}
+
+// blockCurrentTask marks the task of the current iteration as blocked so that the next
+// iterations skip it.
+func blockCurrentTask(reason string) error {
+	tasksMu.Lock()
+	defer tasksMu.Unlock()
+
+	doc, err := LoadTasks()
+	if err != nil {
+		return err
+	}
+	task := doc.Find(session.TaskID)
+	if task == nil || !task.State.Open() {
+		task = doc.Next()
+	}
+	if task == nil {
+		return nil
+	}
+	if err := doc.Block(task.ID, reason); err != nil {
+		return err
+	}
+	log.Printf("Blocked task #%d: %s", task.ID, task.Title)
+	return doc.Save(TasksPath())
+}
+
+// activeModel returns model, or the escalation model once the conversation was escalated.
+func activeModel(model ModelConfig) ModelConfig {
+	if loop.Escalated && config.Models.Escalation.Model != "" {
+		return config.Models.Escalation
+	}
+	return model
+}
@@ -1,137 +1,426 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"strings"
 
 	"github.com/sashabaranov/go-openai"
 )
 
-var client *openai.Client
+const usageText = `Usage: dev <command> [flags] [working directory]
+
+Commands:
+  run       Start a new run: plan INPUT.md, work on the tasks and review them (default)
+  plan      Only plan the run, or print the plan when it was already made
+  resume    Continue the run saved in .dev/session.json
+  wiki      Write the documentation of the project to the wiki folder
+  tools     List the tools of the agent: dev tools list
+  report    Print the report of the last run
+  version   Print the version of dev
+
+Run "dev <command> -h" for the flags of a command.
+`
 
 func main() {
-	if len(os.Args) < 2 {
-		workingDirectory = "."
-	} else {
-		workingDirectory = os.Args[1]
+	// dev [flags] [working directory] is dev run, like before there were commands.
+	args := os.Args[1:]
+	command := "run"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
 	}
-	if _, err := os.Stat(workingDirectory); os.IsNotExist(err) {
-		fmt.Printf("Working directory %s does not exist", workingDirectory)
-		os.Exit(1)
+
+	switch command {
+	case "run", "plan", "resume":
+		runCommand(command, args)
+	case "wiki":
+		wikiCommand(args)
+	case "tools":
+		toolsCommand(args)
+	case "report":
+		reportCommand(args)
+	case "version":
+		fmt.Printf("dev %s\n", Version())
+	case "help":
+		fmt.Print(usageText)
+	default:
+		if _, err := os.Stat(command); err != nil {
+			fmt.Printf("Unknown command %q\n\n%s", command, usageText)
+			os.Exit(2)
+		}
+		runCommand("run", os.Args[1:])
 	}
+}
 
-	if !filepath.IsAbs(workingDirectory) {
-		var err error
-		workingDirectory, err = filepath.Abs(workingDirectory)
+// run drives the session through its phases until the run is done. Every step starts
+// from the saved session, so it also continues a resumed run.
+func run(ctx context.Context) error {
+	if session.Input == "" {
+		input, _ := os.ReadFile(Path("INPUT.md"))
+		session.Input = string(input)
+	}
+	if session.StartSnapshot == "" {
+		snapshot, err := Snapshot(ctx)
 		if err != nil {
-			fmt.Printf("Error converting working directory to absolute path: %s", err)
-			os.Exit(1)
+			log.Printf("Failed to snapshot the working tree, the run report will not list the changed files: %s", err)
 		}
+		session.StartSnapshot = snapshot
 	}
+	for {
+		saveSession()
 
-	key := os.Getenv("OPENROUTER_API_KEY")
-	if key == "" {
-		fmt.Printf("OPENROUTER_API_KEY is not set")
-		os.Exit(1)
-	}
-	config := openai.DefaultConfig(key)
-	config.BaseURL = "https://openrouter.ai/api/v1"
-	client = openai.NewClientWithConfig(config)
+		if session.OnlyPhase != "" && session.Phase != session.OnlyPhase {
+			log.Printf("The %s phase is finished", session.OnlyPhase)
+			session.OnlyPhase = ""
+			saveSession()
+			return nil
+		}
 
-	if _, err := os.Stat(filepath.Join(workingDirectory, "INPUT.md")); os.IsNotExist(err) {
-		fmt.Printf("Input file INPUT.md does not exist in the working directory %s", workingDirectory)
-		os.Create(filepath.Join(workingDirectory, "INPUT.md"))
-		os.Exit(1)
-	}
+		// The usage of a task is labelled once the task is chosen, the other calls with the phase.
+		switch {
+		case session.Phase != PhaseTask:
+			usage.SetTask(session.Phase)
+		case len(messages) > 0 && session.TaskID != 0:
+			usage.SetTask(taskLabel(session.TaskID))
+		}
+
+		switch session.Phase {
+		case PhaseClarify:
+			if err := clarify(ctx); err != nil {
+				return err
+			}
+
+		case PhasePlan:
+			if err := planTasks(ctx); err != nil {
+				return err
+			}
+
+		case PhaseTask:
+			var msg openai.ChatCompletionMessage
+			if len(messages) == 0 {
+				doc, err := LoadTasks()
+				if err != nil {
+					return fmt.Errorf("reading TASKS.md: %w", err)
+				}
+				session.Iteration++
+				session.Tasks = doc.String()
+
+				var note string
+				var loopErr *LoopError
+				if errors.As(loop.ObserveTasks(session.Tasks), &loopErr) {
+					action := loopAction(loopErr)
+					if loop.Stalls > MaxNudges && action != LoopAbort {
+						action = LoopBlock
+					}
+					log.Printf("%s, action: %s", loopErr, action)
+					switch action {
+					case LoopAbort:
+						return loopErr
+					case LoopBlock:
+						if err := blockCurrentTask(loopErr.Reason); err != nil {
+							return err
+						}
+						if err := rollbackTask(ctx, session.TaskID); err != nil {
+							return err
+						}
+						continue
+					case LoopEscalate:
+						loop.Escalated = true
+					}
+					note = nudge(loopErr.Reason)
+				}
+				if unmet := unmetItems(session.Verdict); unmet != "" {
+					note = strings.TrimSpace(note + "\n" + unmet)
+				}
+
+				if config.Parallel > 1 && session.OnlyTask == 0 {
+					ran, err := runParallel(ctx, doc)
+					if err != nil {
+						return err
+					}
+					if ran {
+						continue
+					}
+				}
+
+				task := doc.Next()
+				if session.OnlyTask != 0 {
+					if task = doc.Find(session.OnlyTask); task == nil || !task.State.Open() {
+						log.Printf("Task #%d is finished", session.OnlyTask)
+						session.SetPhase(PhaseDone)
+						continue
+					}
+				}
+				if task == nil {
+					log.Printf("No open tasks left, reviewing")
+					session.SetPhase(PhaseReview)
+					continue
+				}
+				if max := config.Loop.MaxTaskAttempts; max > 0 && doc.Meta(task.ID).Attempts >= max {
+					log.Printf("Task #%d failed %d times, blocking it", task.ID, max)
+					if err := doc.Block(task.ID, fmt.Sprintf("gave up after %d attempts", max)); err != nil {
+						return err
+					}
+					if err := doc.Save(TasksPath()); err != nil {
+						return fmt.Errorf("writing TASKS.md: %w", err)
+					}
+					if err := rollbackTask(ctx, task.ID); err != nil {
+						return err
+					}
+					continue
+				}
+				if err := doc.Start(task.ID); err != nil {
+					return err
+				}
+				if err := doc.Save(TasksPath()); err != nil {
+					return fmt.Errorf("writing TASKS.md: %w", err)
+				}
+				session.TaskID = task.ID
+				usage.SetTask(taskLabel(task.ID))
+				snapshotTask(ctx)
+				log.Printf("Task #%d: %s", task.ID, task.Title)
+				msg = openai.ChatCompletionMessage{
+					Role:    openai.ChatMessageRoleUser,
+					Content: taskPrompt(doc, task, note),
+				}
+			}
+			response, err := converse(ctx, config.Models.Executor, msg)
+			if err != nil {
+				return err
+			}
+			if response == "no_response" {
+				log.Printf("No response from assistant, finishing")
+				session.SetPhase(PhaseDone)
+				continue
+			}
+			if response != TaskBlocked {
+				session.Response = response
+			}
+			if err := rollbackTask(ctx, session.TaskID); err != nil {
+				return err
+			}
+			if config.AutoCommit {
+				if err := commitCompletedTask(ctx); err != nil {
+					return err
+				}
+			}
+			session.SetPhase(PhaseTask)
+
+		case PhaseReview:
+			verdict, err := Judge(ctx, fmt.Sprintf(`Have all the tasks been completed?
+
+			Tasks:
+			%s
+
+			Response:
+			%s
 
-	if _, err := os.Stat(filepath.Join(workingDirectory, "TASKS.md")); os.IsNotExist(err) {
-		os.Create(filepath.Join(workingDirectory, "TASKS.md"))
+			Changes:
+			%s
+			`, session.Tasks, session.Response, changesSummary(ctx)))
+			if err != nil {
+				return err
+			}
+			session.Verdict = &verdict
+			if !verdict.Answer {
+				log.Printf("Tasks not completed, continuing: %s", verdict.Rationale)
+				if err := addReviewTasks(verdict); err != nil {
+					return err
+				}
+				session.SetPhase(PhaseTask)
+				continue
+			}
+			placeholders, err := ScanPlaceholders(ctx)
+			if err != nil {
+				return err
+			}
+			for _, placeholder := range placeholders {
+				log.Printf("Placeholder: %s", placeholder)
+			}
+			added, err := addPlaceholderTasks(placeholders)
+			if err != nil {
+				return err
+			}
+			if added > 0 {
+				log.Printf("%d placeholders left, continuing", added)
+				session.SetPhase(PhaseTask)
+				continue
+			}
+			results, err := RunGates(ctx)
+			if err != nil {
+				return err
+			}
+			session.Gates = results
+			if err := WriteGateReport(results); err != nil {
+				fmt.Printf("Error writing gate report: %s", err)
+			}
+			added, err = addGateTasks(results)
+			if err != nil {
+				return err
+			}
+			if added > 0 {
+				log.Printf("%d gates failed, continuing", added)
+				session.SetPhase(PhaseTask)
+				continue
+			}
+			if !gatesPassed(results) {
+				log.Printf("Gates still fail after their tasks were blocked, keeping INPUT.md, see .dev/report.md")
+				session.SetPhase(PhaseDone)
+				continue
+			}
+			// Erase the INPUT.md file
+			if err := writeFileAtomic(filepath.Join(workingDirectory, "INPUT.md"), []byte{}, 0644); err != nil {
+				fmt.Printf("Error erasing INPUT.md: %s", err)
+			}
+			session.SetPhase(PhaseDone)
+
+		case PhaseDone:
+			return nil
+
+		default:
+			return fmt.Errorf("unknown phase %q", session.Phase)
+		}
 	}
+}
 
-	// GenWiki()
+// taskLabel labels the usage of a task in .dev/usage.md and the run report.
+func taskLabel(id int) string {
+	return fmt.Sprintf("task #%d", id)
+}
 
-	handleChatCompletion(openai.ChatCompletionMessage{
+// planTasks turns INPUT.md into the tasks of TASKS.md and moves the run to its first task.
+func planTasks(ctx context.Context) error {
+	if _, err := converse(ctx, config.Models.Planner, openai.ChatCompletionMessage{
 		Role: openai.ChatMessageRoleUser,
 		Content: `
-			Open a file called INPUT.md and read the content.
-			Process the content of the INPUT.md file into independent, small tasks and add them to the markdown checklist in the TASKS.md file.
+			Open a file called INPUT.md and read the content, and look at the project as much as you need.
+			Then split the work into small tasks and submit them with submit_plan: give every task an id,
+			the ids of the tasks it depends on, its size (s, m or l) and the files it will change.
+			Only add a dependency when a task really needs the other one done first, tasks without one can run in parallel.
+			Give every task acceptance checks, they decide when the task is done:
+			"<shell command>" must exit with 0, "exists <path>" must exist,
+			"grep <regexp> <path>" must match and "!grep <regexp> <path>" must not.
+			If the plan is not valid, fix it and submit it again.
+		` + clarifications(session.Questions),
+	}); err != nil {
+		return err
+	}
+	if session.Plan == nil {
+		log.Printf("No plan was submitted, working on the tasks of TASKS.md")
+	}
+	session.SetPhase(PhaseTask)
+	return nil
+}
 
-			If the file TASKS.md does not exist, create it.
-		`,
-	})
+// printPlan prints .dev/plan.json, or the tasks when the planner did not submit a plan.
+func printPlan() {
+	plan, err := LoadPlan()
+	if err != nil {
+		fmt.Print(ListTasks())
+		return
+	}
+	fmt.Print(plan.Format())
+}
 
+// converse starts the conversation of the current phase with the system prompt and msg,
+// or continues it when it was restored from a session. Loops are handled according to the loop action;
+// TaskBlocked is returned when the conversation was given up.
+func converse(ctx context.Context, model ModelConfig, msg openai.ChatCompletionMessage) (string, error) {
 	for {
-		messages = nil
-		tasks, err := os.ReadFile(filepath.Join(workingDirectory, "TASKS.md"))
-		if err != nil {
-			fmt.Printf("Error reading TASKS.md: %s", err)
-			os.Exit(1)
+		var response string
+		var err error
+		if len(messages) > 0 {
+			response, err = continueChatCompletion(ctx, activeModel(model))
+		} else {
+			messages = []openai.ChatCompletionMessage{SystemMessage(ctx)}
+			response, err = handleChatCompletion(ctx, activeModel(model), msg)
 		}
-		response := handleChatCompletion(openai.ChatCompletionMessage{
-			Role: openai.ChatMessageRoleUser,
-			Content: fmt.Sprintf(`
-				Do the next task.
-				After each task, update the TASKS.md file to reflect the changes.
-
-				TASKS:
-				%s
-			`, string(tasks)),
-		})
-		if response == "no_response" {
-			log.Printf("No response from assistant, finishing")
-			break
+
+		var loopErr *LoopError
+		if !errors.As(err, &loopErr) {
+			return response, err
 		}
-		if !YesNoQuestion(fmt.Sprintf(`Has all the tasks been completed?
-		
-		Tasks:
-		%s
-		
-		Response:
-		%s
-		
-		`, string(tasks), response)) {
-			log.Printf("Tasks not completed, continuing")
-			continue
+		blocked, err := recoverFromLoop(loopErr)
+		if err != nil {
+			return "", err
 		}
-		if ArePendingTodos() {
-			diff, err := exec.Command("git", "diff").Output()
-			if err != nil {
-				fmt.Printf("Error running git diff: %s", err)
-				os.Exit(1)
-			}
-			handleChatCompletion(openai.ChatCompletionMessage{
-				Role: openai.ChatMessageRoleUser,
-				Content: fmt.Sprintf(`
-					Create tasks in the TASKS.md file to implement the missing functionality based on the TODOs, placeholders, etc. in the following git diff:
-
-					git diff:
-					%s
-					`, string(diff)),
-			})
-			log.Printf("There are pending todos, continuing")
-			continue
+		if blocked {
+			return TaskBlocked, nil
 		}
-		// Erase the INPUT.md file
-		if err := os.WriteFile(filepath.Join(workingDirectory, "INPUT.md"), []byte{}, 0644); err != nil {
-			fmt.Printf("Error erasing INPUT.md: %s", err)
+	}
+}
+
+// stop saves the session and exits after an error the agent cannot recover from.
+func stop(err error) {
+	saveSession()
+	WriteRunReport(context.Background(), err)
+	if err := WriteUsageReport(); err != nil {
+		fmt.Printf("Error writing usage report: %s\n", err)
+	}
+	message, code := stopMessage(err)
+	fmt.Print(message)
+	os.Exit(code)
+}
+
+// stopMessage tells what to do after the run stopped with err, and the exit code.
+func stopMessage(err error) (string, int) {
+	var budgetErr *BudgetError
+	if errors.As(err, &budgetErr) {
+		return fmt.Sprintf("Stopping: %s. See .dev/usage.md, raise the budget in %s and run `dev resume` to continue.\n", err, ConfigFile), 3
+	}
+	if errors.Is(err, ErrAwaitingAnswers) {
+		return fmt.Sprintf("Answer the questions in %s and run `dev resume` to continue.\n", QuestionsPath()), 5
+	}
+	if errors.Is(err, context.Canceled) {
+		return "Interrupted, the session was saved.\nRun `dev resume` to continue.\n", 130
+	}
+	var loopErr *LoopError
+	if errors.As(err, &loopErr) {
+		if loopErr.Hard {
+			// The steps of the run are kept in the session, resuming with the same limit stops again.
+			return fmt.Sprintf("Stopping: %s\nRun `dev resume -max-steps %d` with a higher limit to continue.\n", err, 2*config.Loop.MaxStepsPerRun), 4
 		}
-		break
+		return fmt.Sprintf("Stopping: %s\nRun `dev resume` to continue.\n", err), 4
+	}
+	var retryErr *RetryError
+	if errors.As(err, &retryErr) {
+		return fmt.Sprintf("The provider is unavailable, stopping: %s\nRun `dev resume` to continue.\n", err), 2
 	}
+	return fmt.Sprintf("Stopping: %s\nRun `dev resume` to continue.\n", err), 1
 }
 
-func ArePendingTodos() bool {
-	diff, err := exec.Command("git", "diff").Output()
+// addReviewTasks adds a task for every item the review found unmet, so that the
+// next iterations work on them.
+func addReviewTasks(verdict Verdict) error {
+	doc, err := LoadTasks()
 	if err != nil {
-		fmt.Printf("Error running git diff: %s", err)
-		return false
+		return fmt.Errorf("reading TASKS.md: %w", err)
 	}
+	items := verdict.Unmet
+	if len(items) == 0 {
+		items = []string{"Address the review: " + verdict.Rationale}
+	}
+	for _, item := range items {
+		if _, err := doc.Add(item, 0); err != nil {
+			return err
+		}
+	}
+	return doc.Save(TasksPath())
+}
 
-	return YesNoQuestion(fmt.Sprintf(`
-	Check if there are any TODOs, placeholders, etc. in the following git diff:
-	
-	%s
-	`, string(diff)))
+// unmetItems describes what the last review found missing, for the next task prompt.
+func unmetItems(verdict *Verdict) string {
+	if verdict == nil || verdict.Answer {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("The last review found that the tasks are not complete yet: " + verdict.Rationale + "\n")
+	for _, item := range verdict.Unmet {
+		b.WriteString("- " + item + "\n")
+	}
+	return b.String()
 }
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"dev/tasks"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func toolCallInteraction(model string, calls ...openai.FunctionCall) Interaction {
+	message := openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant}
+	for i, call := range calls {
+		message.ToolCalls = append(message.ToolCalls, openai.ToolCall{
+			ID:       fmt.Sprintf("call_%s_%d", call.Name, i),
+			Type:     openai.ToolTypeFunction,
+			Function: call,
+		})
+	}
+	return Interaction{
+		Request:  openai.ChatCompletionRequest{Model: model},
+		Response: openai.ChatCompletionResponse{Choices: []openai.ChatCompletionChoice{{Message: message}}},
+	}
+}
+
+func textInteraction(model string, text string) Interaction {
+	return Interaction{
+		Request: openai.ChatCompletionRequest{Model: model},
+		Response: openai.ChatCompletionResponse{Choices: []openai.ChatCompletionChoice{
+			{Message: openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: text}},
+		}},
+	}
+}
+
+func verdictInteraction(model string, answer string, unmet ...string) Interaction {
+	return toolCallInteraction(model, call("verdict", map[string]any{
+		"verdict":    answer,
+		"rationale":  "because",
+		"confidence": 0.9,
+		"unmet":      unmet,
+	}))
+}
+
+func call(name string, arguments map[string]any) openai.FunctionCall {
+	data, _ := json.Marshal(arguments)
+	return openai.FunctionCall{Name: name, Arguments: string(data)}
+}
+
+// setupRun prepares a working directory with the given INPUT.md and a replay of the
+// cassette served over HTTP, and resets the global run state.
+func setupRun(t *testing.T, input string, cassette *Cassette) *ReplayProvider {
+	t.Helper()
+
+	workingDirectory = t.TempDir()
+	if err := os.WriteFile(filepath.Join(workingDirectory, "INPUT.md"), []byte(input), 0644); err != nil {
+		t.Fatalf("Failed to write INPUT.md: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(workingDirectory, "TASKS.md"), nil, 0644); err != nil {
+		t.Fatalf("Failed to write TASKS.md: %v", err)
+	}
+
+	// Round-trip the cassette through a file, like a recorded one.
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	if err := cassette.Save(path); err != nil {
+		t.Fatalf("Failed to save cassette: %v", err)
+	}
+	loaded, err := LoadCassette(path)
+	if err != nil {
+		t.Fatalf("Failed to load cassette: %v", err)
+	}
+	replay := NewReplayProvider(loaded)
+	server := httptest.NewServer(replay)
+	t.Cleanup(server.Close)
+
+	config = DefaultConfig()
+	config.SetModel("test-model")
+	config.Retry = RetryConfig{MaxAttempts: 1}
+	provider = NewOpenAICompatibleProvider(server.URL+"/v1", "test")
+	messages = nil
+	usage = &UsageTracker{}
+	loop = &LoopDetector{}
+	session = NewSession()
+	t.Cleanup(func() {
+		provider = nil
+		session = nil
+		messages = nil
+		config = Config{}
+		usage = &UsageTracker{}
+	})
+	return replay
+}
+
+func TestRunReplay(t *testing.T) {
+	const model = "test-model"
+	cassette := &Cassette{Interactions: []Interaction{
+		// Planning
+		toolCallInteraction(model, call("read_file", map[string]any{"path": "INPUT.md"})),
+		toolCallInteraction(model, call("add_task", map[string]any{"title": "Create hello.txt saying hello", "notes": []string{"accept: grep ^hello$ hello.txt"}})),
+		textInteraction(model, "Planned one task."),
+		// Executing the task
+		toolCallInteraction(model, call("write_file", map[string]any{"path": "hello.txt", "content": "hello\n"})),
+		toolCallInteraction(model, call("complete_task", map[string]any{"id": 1})),
+		textInteraction(model, "Created hello.txt."),
+		// Judging
+		verdictInteraction(model, "yes"),
+	}}
+	replay := setupRun(t, "Create a hello.txt file saying hello.\n", cassette)
+	if err := os.WriteFile(filepath.Join(workingDirectory, "AGENTS.md"), []byte("Always greet in lowercase."), 0644); err != nil {
+		t.Fatalf("Failed to write AGENTS.md: %v", err)
+	}
+
+	if err := run(context.Background()); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+
+	if remaining := replay.Remaining(); remaining != 0 {
+		t.Errorf("Expected the whole cassette to be replayed, %d interactions left", remaining)
+	}
+	for _, i := range []int{0, 3} {
+		system := replay.Received[i].Messages[0]
+		if system.Role != openai.ChatMessageRoleSystem || !strings.Contains(system.Content, "Always greet in lowercase.") || !strings.Contains(system.Content, "# Environment") {
+			t.Errorf("Expected conversation %d to start with the system prompt, got %+v", i, system)
+		}
+	}
+	if prompt := replay.Received[3].Messages[1].Content; !strings.Contains(prompt, "Your task is #1: Create hello.txt saying hello") {
+		t.Errorf("Expected the executor to be given task #1, got %q", prompt)
+	}
+	hello, err := os.ReadFile(filepath.Join(workingDirectory, "hello.txt"))
+	if err != nil || string(hello) != "hello\n" {
+		t.Errorf("Expected hello.txt to be written, got %q (%v)", hello, err)
+	}
+	tasks, _ := os.ReadFile(filepath.Join(workingDirectory, "TASKS.md"))
+	if string(tasks) != "- [x] Create hello.txt saying hello <!-- id:1 -->\n  accept: grep ^hello$ hello.txt\n" {
+		t.Errorf("Expected the task to be checked, got %q", tasks)
+	}
+	input, _ := os.ReadFile(filepath.Join(workingDirectory, "INPUT.md"))
+	if len(input) != 0 {
+		t.Errorf("Expected INPUT.md to be erased, got %q", input)
+	}
+	if session.Phase != PhaseDone {
+		t.Errorf("Expected the session to be done, got phase %s", session.Phase)
+	}
+	saved, err := LoadSession()
+	if err != nil || saved.Phase != PhaseDone {
+		t.Errorf("Expected the saved session to be done, got %+v (%v)", saved, err)
+	}
+	for label, calls := range map[string]int{"plan": 3, "task #1": 3, "review": 1} {
+		if got := usage.Tasks[label].Calls; got != calls {
+			t.Errorf("Expected %d calls for %s, got %d (%v)", calls, label, got, usage.Tasks)
+		}
+	}
+}
+
+func TestRunStopsOnBudget(t *testing.T) {
+	const model = "test-model"
+	withUsage := func(interaction Interaction) Interaction {
+		interaction.Response.Usage = openai.Usage{PromptTokens: 1000, CompletionTokens: 100, TotalTokens: 1100}
+		return interaction
+	}
+	cassette := &Cassette{Interactions: []Interaction{
+		withUsage(toolCallInteraction(model, call("read_file", map[string]any{"path": "INPUT.md"}))),
+		withUsage(toolCallInteraction(model, call("read_file", map[string]any{"path": "INPUT.md"}))),
+		withUsage(toolCallInteraction(model, call("read_file", map[string]any{"path": "INPUT.md"}))),
+	}}
+	replay := setupRun(t, "Loop forever.\n", cassette)
+	config.Budget = Budget{MaxTokens: 2000}
+	config.Prices = map[string]Price{model: {Prompt: 1, Completion: 2}}
+
+	err := run(context.Background())
+	var budgetErr *BudgetError
+	if !errors.As(err, &budgetErr) {
+		t.Fatalf("Expected a BudgetError, got %v", err)
+	}
+	if replay.Remaining() != 1 {
+		t.Errorf("Expected the run to stop after 2 calls, %d interactions left", replay.Remaining())
+	}
+	if usage.Run.Calls != 2 || usage.Run.TotalTokens() != 2200 || usage.Tasks["plan"].Calls != 2 {
+		t.Errorf("Unexpected usage: %+v", usage.Run)
+	}
+	if want := 2 * (1000*1 + 100*2) / 1e6; usage.Run.Cost != want {
+		t.Errorf("Expected cost %f, got %f", want, usage.Run.Cost)
+	}
+}
+
+func TestRunNudgesOnRepeatedToolCalls(t *testing.T) {
+	const model = "test-model"
+	read := toolCallInteraction(model, call("read_file", map[string]any{"path": "INPUT.md"}))
+	cassette := &Cassette{Interactions: []Interaction{
+		read, read, read,
+		// The fourth identical call is dropped and the model is nudged.
+		read,
+		textInteraction(model, "Nothing to plan."),
+		// There are no tasks, the review passes.
+		verdictInteraction(model, "yes"),
+	}}
+	replay := setupRun(t, "Read the input.\n", cassette)
+
+	if err := run(context.Background()); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if replay.Remaining() != 0 {
+		t.Errorf("Expected the whole cassette to be replayed, %d interactions left", replay.Remaining())
+	}
+	nudged := replay.Received[4].Messages
+	last := nudged[len(nudged)-1]
+	if last.Role != openai.ChatMessageRoleUser || !strings.Contains(last.Content, "going in circles") {
+		t.Errorf("Expected a nudge before the fifth request, got %+v", last)
+	}
+	// System prompt and input, 3 reads answered, the fourth dropped.
+	if n := len(nudged); n != 2+3*2+1 {
+		t.Errorf("Expected 9 messages in the nudged request, got %d", n)
+	}
+}
+
+func TestRunBlocksTaskAfterMaxAttempts(t *testing.T) {
+	const model = "test-model"
+	cassette := &Cassette{Interactions: []Interaction{
+		toolCallInteraction(model, call("add_task", map[string]any{"title": "Impossible"})),
+		textInteraction(model, "Planned."),
+		// Three attempts that do not finish the task, the first leaves it in progress.
+		toolCallInteraction(model, call("write_file", map[string]any{"path": "early.txt", "content": "started\n"})),
+		textInteraction(model, "I could not do it."),
+		toolCallInteraction(model, call("write_file", map[string]any{"path": "partial.txt", "content": "half done\n"})),
+		toolCallInteraction(model, call("fail_task", map[string]any{"id": 1, "reason": "missing tool"})),
+		textInteraction(model, "Failed."),
+		textInteraction(model, "Still no."),
+		verdictInteraction(model, "yes"),
+	}}
+	replay := setupRun(t, "Do the impossible.\n", cassette)
+
+	if err := run(context.Background()); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if replay.Remaining() != 0 {
+		t.Errorf("Expected the whole cassette to be replayed, %d interactions left", replay.Remaining())
+	}
+	if prompt := replay.Received[7].Messages[1].Content; !strings.Contains(prompt, "This is attempt 3 of the task. The last attempt failed: missing tool") {
+		t.Errorf("Expected the third attempt to know about the failure, got %q", prompt)
+	}
+	for _, name := range []string{"early.txt", "partial.txt"} {
+		if _, err := os.Stat(filepath.Join(workingDirectory, name)); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be rolled back to when the task first started, got %v", name, err)
+		}
+	}
+	if patch, _ := os.ReadFile(FailedPatchPath(1)); !strings.Contains(string(patch), "+half done") {
+		t.Errorf("Expected the failed changes to be kept in a patch, got %q", patch)
+	}
+	content, _ := os.ReadFile(filepath.Join(workingDirectory, "TASKS.md"))
+	want := "- [b] Impossible <!-- id:1 -->\n  failed: missing tool\n  blocked: gave up after 3 attempts\n"
+	if string(content) != want {
+		t.Errorf("Unexpected TASKS.md:\n%s", content)
+	}
+}
+
+func TestRunReviewsAutoCommittedChanges(t *testing.T) {
+	const model = "test-model"
+	cassette := &Cassette{Interactions: []Interaction{
+		toolCallInteraction(model, call("add_task", map[string]any{"title": "Add Hello"})),
+		textInteraction(model, "Planned."),
+		toolCallInteraction(model, call("add_or_edit_function", map[string]any{"path": "main.go", "function_name": "Hello", "function_body": "func Hello() {\n\tpanic(\"not implemented\")\n}"})),
+		toolCallInteraction(model, call("complete_task", map[string]any{"id": 1})),
+		textInteraction(model, "Added Hello."),
+		verdictInteraction(model, "yes"),
+		// The placeholder committed with task #1 becomes task #2.
+		toolCallInteraction(model, call("add_or_edit_function", map[string]any{"path": "main.go", "function_name": "Hello", "function_body": "func Hello() {\n\tprintln(\"hello\")\n}"})),
+		toolCallInteraction(model, call("complete_task", map[string]any{"id": 2})),
+		textInteraction(model, "Said hello."),
+		verdictInteraction(model, "yes"),
+	}}
+	replay := setupRun(t, "Add Hello.\n", cassette)
+	os.WriteFile(filepath.Join(workingDirectory, "main.go"), []byte("package main\n"), 0644)
+	repo := initRepo(t)
+	config.AutoCommit = true
+
+	if err := run(context.Background()); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if replay.Remaining() != 0 || session.Phase != PhaseDone {
+		t.Fatalf("Expected the run to finish, %d interactions left, phase %s", replay.Remaining(), session.Phase)
+	}
+	if status, _ := repo.Status(context.Background()); strings.Contains(status, "main.go") {
+		t.Errorf("Expected main.go to be committed, got status:\n%s", status)
+	}
+	// The judge sees the committed changes of the run.
+	review := replay.Received[5].Messages
+	if prompt := review[len(review)-1].Content; !strings.Contains(prompt, "main.go") {
+		t.Errorf("Expected the committed main.go in the changes given to the judge, got %q", prompt)
+	}
+	doc, _ := LoadTasks()
+	if task := doc.Find(2); task == nil || task.Title != `Resolve the placeholder in main.go: panic("not implemented")` || task.State != tasks.Done {
+		t.Errorf("Expected a task for the committed placeholder, got %+v", task)
+	}
+}
+
+func TestStopMessageAfterStepLimit(t *testing.T) {
+	config = DefaultConfig()
+	config.Loop.MaxStepsPerRun = 2
+	t.Cleanup(func() { config = Config{} })
+
+	detector := &LoopDetector{}
+	var err error
+	for err == nil {
+		err = detector.ObserveTurn(openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: "thinking"})
+	}
+	message, code := stopMessage(err)
+	if code != 4 || !strings.Contains(message, "dev resume -max-steps 4") {
+		t.Errorf("Expected to be told to resume with a higher limit, got %d %q", code, message)
+	}
+
+	// The steps are kept on resume, so the run only goes on with the higher limit.
+	flags := newRunFlags("resume")
+	flags.Parse([]string{"-max-steps", "4"})
+	flags.apply(&config)
+	if err := detector.ObserveTurn(openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: "done"}); err != nil {
+		t.Errorf("Expected the resumed run to go on with -max-steps 4, got %v", err)
+	}
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"dev/tasks"
+)
+
+// With config.Parallel above 1, the independent ready tasks run at the same time, each
+// in its own git worktree under .dev/worktrees with its own dev process. The worktrees
+// start from a commit of the working tree; the changes of the tasks that are done are
+// merged back into the working tree without touching HEAD or the index, and a merge
+// conflict becomes a new task.
+
+// workerCommand starts the dev process that works on one task in dir.
+var workerCommand = func(ctx context.Context, dir string, id int) *exec.Cmd {
+	executable, err := os.Executable()
+	if err != nil {
+		executable = os.Args[0]
+	}
+	return exec.CommandContext(ctx, executable, "run", "-task", strconv.Itoa(id), "-config", filepath.Join(dir, ".dev", "config.json"), dir)
+}
+
+type worker struct {
+	Task   *tasks.Task
+	Dir    string
+	Branch string
+	// Result is the task as the worker left it, and Commit its changes.
+	Result *tasks.Task
+	Meta   tasks.Meta
+	Commit string
+	Err    error
+}
+
+func parallelDir(parts ...string) string {
+	return filepath.Join(append([]string{workingDirectory, ".dev"}, parts...)...)
+}
+
+// runParallel runs the independent ready tasks at the same time and merges their changes
+// back. It returns false when there are fewer than two of them, or when the working
+// directory is not a git repository with a commit.
+func runParallel(ctx context.Context, doc *tasks.Document) (bool, error) {
+	ready := doc.Parallel()
+	if len(ready) < 2 {
+		return false, nil
+	}
+	repo := WorkingRepo()
+	head, err := repo.Head(ctx)
+	if err != nil {
+		log.Printf("Not a git repository with a commit, running the tasks one at a time")
+		return false, nil
+	}
+	if err := usage.CheckBudget(config.Budget); err != nil {
+		return false, err
+	}
+	if len(ready) > config.Parallel {
+		ready = ready[:config.Parallel]
+	}
+
+	tree, err := Snapshot(ctx)
+	if err != nil {
+		return false, err
+	}
+	base, err := repo.CommitTree(ctx, tree, "dev: working tree before the parallel tasks", head)
+	if err != nil {
+		return false, err
+	}
+	repo.git(ctx, "worktree", "prune")
+
+	// Worktrees are added and merged one at a time, only the workers run at the same time.
+	var workers []*worker
+	defer func() {
+		for _, w := range workers {
+			if err := repo.RemoveWorktree(context.WithoutCancel(ctx), w.Dir, w.Branch); err != nil {
+				log.Printf("Failed to remove the worktree of task #%d: %v", w.Task.ID, err)
+			}
+		}
+	}()
+	for _, task := range ready {
+		w := &worker{Task: task, Dir: parallelDir("worktrees", strconv.Itoa(task.ID)), Branch: fmt.Sprintf("dev/task-%d", task.ID)}
+		os.RemoveAll(w.Dir)
+		if err := repo.AddWorktree(ctx, w.Dir, w.Branch, base); err != nil {
+			return false, err
+		}
+		workers = append(workers, w)
+		if err := prepareWorktree(w.Dir, len(ready)); err != nil {
+			return false, err
+		}
+	}
+
+	log.Printf("Running %d tasks in parallel", len(workers))
+	var wg sync.WaitGroup
+	for _, w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.Err = runWorker(ctx, w)
+		}()
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	return true, mergeWorkers(ctx, base, workers)
+}
+
+// prepareWorktree copies the files of the agent and the config to a new worktree, one of
+// the workers of the batch.
+func prepareWorktree(dir string, workers int) error {
+	for _, path := range []string{TasksPath(), tasks.MetaPath(TasksPath()), Path("INPUT.md")} {
+		content, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dir, filepath.Base(path)), content, 0644); err != nil {
+			return err
+		}
+	}
+
+	// Each worker gets its part of what is left of the budget, so the batch cannot spend
+	// more than the run, and leaves committing to the run.
+	workerConfig := config
+	workerConfig.Parallel = 0
+	workerConfig.AutoCommit = false
+	workerConfig.Budget = usage.Remaining(config.Budget).Split(workers)
+	content, err := json.MarshalIndent(workerConfig, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(dir, ".dev"), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, ".dev", "config.json"), content, 0644)
+}
+
+// runWorker runs the worker process, its output goes to .dev/parallel/<id>.log, and
+// commits the changes of its task when it is done.
+func runWorker(ctx context.Context, w *worker) error {
+	if err := os.MkdirAll(parallelDir("parallel"), 0755); err != nil {
+		return err
+	}
+	logFile, err := os.Create(parallelDir("parallel", strconv.Itoa(w.Task.ID)+".log"))
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+
+	command := workerCommand(ctx, w.Dir, w.Task.ID)
+	command.Dir = w.Dir
+	command.Stdout = logFile
+	command.Stderr = logFile
+	runErr := command.Run()
+
+	doc, err := tasks.Load(filepath.Join(w.Dir, "TASKS.md"))
+	if err != nil {
+		return err
+	}
+	w.Result = doc.Find(w.Task.ID)
+	if w.Result == nil {
+		return fmt.Errorf("task #%d is gone from the TASKS.md of its worktree", w.Task.ID)
+	}
+	w.Meta = *doc.Meta(w.Task.ID)
+	if runErr != nil {
+		runErr = fmt.Errorf("the worker of task #%d failed: %w", w.Task.ID, runErr)
+	}
+	if w.Result.State != tasks.Done {
+		return runErr
+	}
+
+	var response string
+	if s, err := loadSession(filepath.Join(w.Dir, ".dev", "session.json")); err == nil {
+		response = s.Response
+	}
+	worktree := Repo{Dir: w.Dir}
+	if err := worktree.Stage(ctx, snapshotPaths()...); err != nil {
+		return err
+	}
+	stat, _ := worktree.Diff(ctx, DiffOptions{Staged: true, Stat: true})
+	w.Commit, err = worktree.Commit(ctx, CommitMessage(w.Result, response, stat), snapshotPaths()...)
+	if errors.Is(err, ErrNothingToCommit) {
+		w.Commit, err = worktree.Head(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	return runErr
+}
+
+// mergeWorkers merges the changes of the done tasks into the working tree and copies
+// the state of every task back to TASKS.md.
+func mergeWorkers(ctx context.Context, base string, workers []*worker) error {
+	tasksMu.Lock()
+	defer tasksMu.Unlock()
+	doc, err := LoadTasks()
+	if err != nil {
+		return fmt.Errorf("reading TASKS.md: %w", err)
+	}
+
+	repo := WorkingRepo()
+	current := base
+	var merged []*tasks.Task
+	for _, w := range workers {
+		id := w.Task.ID
+		if s, err := loadSession(filepath.Join(w.Dir, ".dev", "session.json")); err == nil && s.Usage != nil {
+			usage.Merge(s.Usage, taskLabel(id))
+		}
+		copyFile(filepath.Join(w.Dir, ".dev", "failed", strconv.Itoa(id)+".patch"), FailedPatchPath(id))
+		if w.Result != nil {
+			*doc.Meta(id) = w.Meta
+		}
+
+		switch {
+		case w.Result == nil || w.Result.State == tasks.Done && w.Commit == "" || w.Err != nil && w.Result.State.Open():
+			reason := fmt.Sprintf("%v, see .dev/parallel/%d.log", w.Err, id)
+			log.Printf("Task #%d failed in parallel: %s", id, reason)
+			err = doc.Fail(id, reason)
+		case w.Result.State == tasks.Done:
+			tree, conflicts, mergeErr := repo.MergeTree(ctx, current, w.Commit)
+			if mergeErr != nil {
+				return mergeErr
+			}
+			if len(conflicts) > 0 {
+				err = addConflictTask(ctx, doc, w, base, conflicts)
+				break
+			}
+			current, err = repo.CommitTree(ctx, tree, fmt.Sprintf("dev: merge task #%d", id), current, w.Commit)
+			if err != nil {
+				return err
+			}
+			log.Printf("Merged task #%d: %s", id, w.Task.Title)
+			merged = append(merged, doc.Find(id))
+			err = doc.SetState(id, tasks.Done)
+		case w.Result.State == tasks.Failed:
+			err = doc.Fail(id, w.Meta.LastError)
+		case w.Result.State == tasks.Blocked:
+			err = doc.Block(id, w.Meta.Reason)
+		case w.Result.State == tasks.Skipped:
+			err = doc.Skip(id, w.Meta.Reason)
+		default:
+			// The worker stopped before finishing, the task is worked on again.
+			err = doc.SetState(id, tasks.Pending)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if current != base {
+		if err := switchWorkingTree(ctx, current); err != nil {
+			return fmt.Errorf("merging the parallel tasks: %w", err)
+		}
+	}
+	if err := doc.Save(TasksPath()); err != nil {
+		return fmt.Errorf("writing TASKS.md: %w", err)
+	}
+	if config.AutoCommit && len(merged) > 0 {
+		return commitMerged(ctx, doc, merged)
+	}
+	return nil
+}
+
+// switchWorkingTree updates the working tree, which has not changed since the base
+// commit, to the files of commit.
+func switchWorkingTree(ctx context.Context, commit string) error {
+	repo, cleanup, err := snapshotRepo(ctx)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	tree, err := repo.WriteTree(ctx, snapshotPaths()...)
+	if err != nil {
+		return err
+	}
+	return repo.SwitchTree(ctx, tree, commit)
+}
+
+// addConflictTask marks the task of w done and adds a task to merge its changes by hand,
+// with the same acceptance checks.
+func addConflictTask(ctx context.Context, doc *tasks.Document, w *worker, base string, conflicts []string) error {
+	id := w.Task.ID
+	patch := parallelDir("parallel", strconv.Itoa(id)+".patch")
+	diff, err := WorkingRepo().git(ctx, "diff", "--binary", "--no-color", base, w.Commit)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(patch, []byte(diff), 0644); err != nil {
+		return err
+	}
+	log.Printf("The changes of task #%d conflict in %s, adding a task to merge them", id, strings.Join(conflicts, ", "))
+
+	rel, _ := filepath.Rel(workingDirectory, patch)
+	notes := []string{
+		fmt.Sprintf("The changes of task #%d conflict with the other parallel tasks in %s.", id, strings.Join(conflicts, ", ")),
+		fmt.Sprintf("They are in %s, apply them with `git apply --3way %s` and resolve the conflicts.", rel, rel),
+	}
+	for _, note := range w.Task.Notes {
+		if strings.HasPrefix(note, AcceptPrefix) {
+			notes = append(notes, note)
+		}
+	}
+	if _, err := doc.Add(fmt.Sprintf("Merge the changes of task #%d: %s", id, w.Task.Title), 0, notes...); err != nil {
+		return err
+	}
+	return doc.SetState(id, tasks.Done)
+}
+
+// commitMerged commits the merged changes of the parallel tasks together, with a
+// Task-Id trailer for each of them.
+func commitMerged(ctx context.Context, doc *tasks.Document, merged []*tasks.Task) error {
+	var ids, titles, trailers []string
+	for _, task := range merged {
+		ids = append(ids, fmt.Sprintf("#%d", task.ID))
+		titles = append(titles, fmt.Sprintf("- #%d %s", task.ID, task.Title))
+		trailers = append(trailers, fmt.Sprintf("%s: %d", TaskTrailer, task.ID))
+	}
+	message := fmt.Sprintf("Complete tasks %s\n\n%s\n\n%s\n", strings.Join(ids, ", "), strings.Join(titles, "\n"), strings.Join(trailers, "\n"))
+	hash, err := WorkingRepo().Commit(ctx, message, append([]string{"."}, commitExclude()...)...)
+	if errors.Is(err, ErrNothingToCommit) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, task := range merged {
+		doc.Meta(task.ID).Commit = hash
+	}
+	return doc.Save(TasksPath())
+}
+
+func copyFile(from, to string) {
+	content, err := os.ReadFile(from)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err == nil {
+		os.WriteFile(to, content, 0644)
+	}
+}
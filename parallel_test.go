@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"dev/tasks"
+)
+
+func TestRunParallel(t *testing.T) {
+	workingDirectory = t.TempDir()
+	repo := initRepo(t)
+	ctx := context.Background()
+	head, _ := repo.Head(ctx)
+
+	config = DefaultConfig()
+	config.Parallel = 4
+	config.Budget = Budget{MaxCalls: 100, MaxDollars: 2}
+	usage = &UsageTracker{}
+	usage.Run.Calls = 20
+	t.Cleanup(func() { config = Config{} })
+	os.WriteFile(TasksPath(), []byte(`- [ ] Add a <!-- id:1 -->
+  independent
+- [ ] Say two <!-- id:2 -->
+  independent
+- [ ] Say three <!-- id:3 -->
+  independent
+  accept: grep three README.md
+- [ ] Wait <!-- id:4 -->
+  depends: 2
+- [ ] Fail <!-- id:5 -->
+  independent
+`), 0644)
+
+	// The workers edit the files and check their task instead of asking a model.
+	scripts := map[int]string{1: "echo a > a.txt", 2: "echo two > README.md", 3: "echo three > README.md", 5: "exit 3"}
+	orig := workerCommand
+	t.Cleanup(func() { workerCommand = orig })
+	workerCommand = func(ctx context.Context, dir string, id int) *exec.Cmd {
+		// The 4 workers split the 80 calls and $2 left.
+		var workerConfig Config
+		content, _ := os.ReadFile(filepath.Join(dir, ".dev", "config.json"))
+		if err := json.Unmarshal(content, &workerConfig); err != nil {
+			t.Errorf("Reading the config of task %d: %v", id, err)
+		}
+		if want := (Budget{MaxCalls: 20, MaxDollars: 0.5}); workerConfig.Budget != want {
+			t.Errorf("Expected task %d to get a budget of %+v, got %+v", id, want, workerConfig.Budget)
+		}
+		script := fmt.Sprintf(`sed -i 's/^- \[ \] \(.*id:%d \)/- [x] \1/' TASKS.md`, id)
+		if scripts[id] != "" {
+			script = scripts[id] + " && " + script
+		}
+		return exec.CommandContext(ctx, "sh", "-c", script)
+	}
+
+	doc, _ := LoadTasks()
+	ran, err := runParallel(ctx, doc)
+	if err != nil || !ran {
+		t.Fatalf("runParallel: %t %v", ran, err)
+	}
+
+	for name, want := range map[string]string{"a.txt": "a\n", "README.md": "two\n"} {
+		if content, _ := os.ReadFile(filepath.Join(workingDirectory, name)); string(content) != want {
+			t.Errorf("Expected %s to be merged as %q, got %q", name, want, content)
+		}
+	}
+	doc, _ = LoadTasks()
+	for _, id := range []int{1, 2, 3} {
+		if state := doc.Find(id).State; state != tasks.Done {
+			t.Errorf("Expected task %d to be done, got %s", id, state)
+		}
+	}
+	if state := doc.Find(4).State; state != tasks.Pending {
+		t.Errorf("Expected task 4 to wait for task 2, got %s", state)
+	}
+	if failed := doc.Find(5); failed.State != tasks.Failed || !strings.Contains(doc.Meta(5).LastError, "exit status 3") {
+		t.Errorf("Expected task 5 to fail with its worker, got %s %q", failed.State, doc.Meta(5).LastError)
+	}
+	conflict := doc.Find(6)
+	if conflict == nil || conflict.Title != "Merge the changes of task #3: Say three" || !strings.Contains(strings.Join(conflict.Notes, "\n"), "accept: grep three README.md") {
+		t.Fatalf("Expected a task to merge task 3 by hand, got %+v", conflict)
+	}
+	if patch, _ := os.ReadFile(parallelDir("parallel", "3.patch")); !strings.Contains(string(patch), "+three") {
+		t.Errorf("Expected the conflicting changes in a patch, got %q", patch)
+	}
+
+	if after, _ := repo.Head(ctx); after != head {
+		t.Errorf("Expected HEAD to be left alone")
+	}
+	if worktrees, _ := repo.git(ctx, "worktree", "list"); strings.Count(worktrees, "\n") != 1 {
+		t.Errorf("Expected the worktrees to be removed, got:\n%s", worktrees)
+	}
+	if branches, _ := repo.git(ctx, "branch", "--list", "dev/*"); branches != "" {
+		t.Errorf("Expected the branches to be removed, got:\n%s", branches)
+	}
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"dev/tasks"
+)
+
+// A Placeholder is unfinished work left in a Go file.
+type Placeholder struct {
+	Path string // relative to the working directory
+	Line int
+	End  int    // last line of the function, for empty-body and stub
+	Kind string // todo, not-implemented, empty-body, stub, elided or syntax
+	Text string
+}
+
+func (p Placeholder) String() string {
+	return fmt.Sprintf("%s:%d: %s: %s", p.Path, p.Line, p.Kind, p.Text)
+}
+
+var (
+	todoComment        = regexp.MustCompile(`\b(TODO|FIXME)\b`)
+	elidedComment      = regexp.MustCompile(`(?i)\.\.\.\s*(existing|rest of|remaining|other)\b.*\.\.\.|^\s*rest of the \w+\s*\.\.\.|^\s*\.\.\.\s*$`)
+	notImplemented     = regexp.MustCompile(`(?i)not (yet )?implemented|unimplemented|\btodo\b`)
+	placeholderComment = regexp.MustCompile(`(?i)placeholder|\bstub\b|\bdummy\b|implement (me|this|later)`)
+)
+
+// ScanPlaceholders scans the lines added to the Go files changed since the run started,
+// committed or not, so the placeholders that were already there are left alone. Syntax errors are reported
+// wherever they are.
+func ScanPlaceholders(ctx context.Context) ([]Placeholder, error) {
+	files, diffable, err := changedGoFiles(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var placeholders []Placeholder
+	for _, file := range files {
+		found, err := ScanFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if !diffable {
+			placeholders = append(placeholders, found...)
+			continue
+		}
+		added, err := addedLines(ctx, file)
+		if err != nil {
+			return nil, err
+		}
+		for _, placeholder := range found {
+			if added == nil || placeholder.Kind == "syntax" || placeholder.added(added) {
+				placeholders = append(placeholders, placeholder)
+			}
+		}
+	}
+	return placeholders, nil
+}
+
+// added reports whether one of the lines of the placeholder is in added.
+func (p Placeholder) added(added map[int]bool) bool {
+	for line := p.Line; line <= max(p.Line, p.End); line++ {
+		if added[line] {
+			return true
+		}
+	}
+	return false
+}
+
+// addedLines returns the lines of file added since the run started, or since the last
+// commit without a start snapshot. nil means every line.
+func addedLines(ctx context.Context, file string) (map[int]bool, error) {
+	diff, started, err := DiffSinceStart(ctx, []string{"-U0"}, file)
+	if !started {
+		return WorkingRepo().AddedLines(ctx, file)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return hunkLines(diff), nil
+}
+
+// changedGoFiles returns the Go files changed since the run started, and whether they
+// can be diffed. Without a start snapshot they are the files changed since the last
+// commit and the untracked ones, and outside of a git repository with a commit every Go
+// file is scanned.
+func changedGoFiles(ctx context.Context) ([]string, bool, error) {
+	var changed []string
+	if names, started, err := DiffSinceStart(ctx, []string{"--name-only"}); started {
+		if err != nil {
+			return nil, false, err
+		}
+		for _, file := range strings.Split(names, "\n") {
+			if _, err := os.Stat(Path(file)); file != "" && err == nil {
+				changed = append(changed, file)
+			}
+		}
+	} else {
+		repo := WorkingRepo()
+		if _, err := repo.Head(ctx); err != nil {
+			if ctx.Err() != nil {
+				return nil, false, ctx.Err()
+			}
+			files, err := allGoFiles()
+			return files, false, err
+		}
+		if changed, err = repo.ChangedFiles(ctx); err != nil {
+			return nil, false, err
+		}
+	}
+	var files []string
+	for _, file := range changed {
+		if strings.HasSuffix(file, ".go") {
+			files = append(files, file)
+		}
+	}
+	return files, true, nil
+}
+
+func allGoFiles() ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(workingDirectory, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != workingDirectory && (strings.HasPrefix(d.Name(), ".") || d.Name() == "vendor") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasSuffix(path, ".go") {
+			rel, _ := filepath.Rel(workingDirectory, path)
+			files = append(files, rel)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// ScanFile finds the placeholders of one Go file, given relative to the working directory.
+func ScanFile(path string) ([]Placeholder, error) {
+	content, err := os.ReadFile(Path(path))
+	if err != nil {
+		return nil, err
+	}
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, path, content, parser.ParseComments)
+	if err != nil {
+		line, text := 1, err.Error()
+		var list scanner.ErrorList
+		if errors.As(err, &list) && len(list) > 0 {
+			line, text = list[0].Pos.Line, list[0].Msg
+		}
+		return []Placeholder{{Path: path, Line: line, Kind: "syntax", Text: text}}, nil
+	}
+
+	var placeholders []Placeholder
+	add := func(node ast.Node, kind, text string) {
+		placeholders = append(placeholders, Placeholder{Path: path, Line: fset.Position(node.Pos()).Line, End: fset.Position(node.End()).Line, Kind: kind, Text: strings.TrimSpace(text)})
+	}
+
+	for _, group := range file.Comments {
+		for _, comment := range group.List {
+			text := strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(comment.Text, "//"), "/*"), "*/"))
+			switch {
+			case todoComment.MatchString(text):
+				add(comment, "todo", text)
+			case elidedComment.MatchString(text):
+				add(comment, "elided", text)
+			}
+		}
+	}
+
+	ast.Inspect(file, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.CallExpr:
+			if ident, ok := node.Fun.(*ast.Ident); ok && ident.Name == "panic" && len(node.Args) == 1 {
+				if lit, ok := node.Args[0].(*ast.BasicLit); ok && lit.Kind == token.STRING && notImplemented.MatchString(lit.Value) {
+					add(node, "not-implemented", fmt.Sprintf("panic(%s)", lit.Value))
+				}
+			}
+		case *ast.FuncDecl:
+			if node.Body == nil {
+				return true
+			}
+			if len(node.Body.List) == 0 && !isMarkerMethod(node) && !hasComments(file, node.Body) {
+				add(node, "empty-body", fmt.Sprintf("func %s has an empty body", node.Name.Name))
+			}
+			if returnsZeroValues(node.Body) && placeholderComment.MatchString(funcComments(file, node)) {
+				add(node, "stub", fmt.Sprintf("func %s only returns zero values", node.Name.Name))
+			}
+		}
+		return true
+	})
+
+	sort.SliceStable(placeholders, func(i, j int) bool { return placeholders[i].Line < placeholders[j].Line })
+	return placeholders, nil
+}
+
+// isMarkerMethod reports whether fn is an unexported method without parameters or
+// results, like the isNode() methods used to close an interface.
+func isMarkerMethod(fn *ast.FuncDecl) bool {
+	return fn.Recv != nil && !fn.Name.IsExported() && fn.Type.Params.NumFields() == 0 && fn.Type.Results.NumFields() == 0
+}
+
+func hasComments(file *ast.File, block *ast.BlockStmt) bool {
+	for _, group := range file.Comments {
+		if group.Pos() > block.Lbrace && group.End() < block.Rbrace {
+			return true
+		}
+	}
+	return false
+}
+
+// funcComments returns the doc comment of fn and the comments inside it.
+func funcComments(file *ast.File, fn *ast.FuncDecl) string {
+	var text []string
+	if fn.Doc != nil {
+		text = append(text, fn.Doc.Text())
+	}
+	for _, group := range file.Comments {
+		if group.Pos() > fn.Body.Lbrace && group.End() < fn.Body.Rbrace {
+			text = append(text, group.Text())
+		}
+	}
+	return strings.Join(text, "\n")
+}
+
+// returnsZeroValues reports whether body is a single return of zero values only.
+func returnsZeroValues(body *ast.BlockStmt) bool {
+	if len(body.List) != 1 {
+		return false
+	}
+	ret, ok := body.List[0].(*ast.ReturnStmt)
+	if !ok || len(ret.Results) == 0 {
+		return false
+	}
+	for _, result := range ret.Results {
+		if !isZeroValue(result) {
+			return false
+		}
+	}
+	return true
+}
+
+func isZeroValue(expr ast.Expr) bool {
+	switch expr := expr.(type) {
+	case *ast.Ident:
+		return expr.Name == "nil" || expr.Name == "false"
+	case *ast.BasicLit:
+		switch expr.Value {
+		case "0", "0.0", `""`, "``":
+			return true
+		}
+	case *ast.CompositeLit:
+		return len(expr.Elts) == 0
+	}
+	return false
+}
+
+// addPlaceholderTasks adds a task for every placeholder and returns how many were added.
+// A placeholder that already has a task is not added again, even when the task was
+// finished or blocked.
+func addPlaceholderTasks(placeholders []Placeholder) (int, error) {
+	tasksMu.Lock()
+	defer tasksMu.Unlock()
+
+	doc, err := LoadTasks()
+	if err != nil {
+		return 0, fmt.Errorf("reading TASKS.md: %w", err)
+	}
+	added := 0
+	for _, placeholder := range placeholders {
+		title := placeholderTitle(placeholder)
+		if task := findTask(doc, title); task != nil {
+			if !task.State.Open() {
+				log.Printf("Placeholder %s is still there, its task #%d is %s", placeholder, task.ID, task.State)
+			}
+			continue
+		}
+		notes := []string{fmt.Sprintf("%s:%d: %s", placeholder.Path, placeholder.Line, placeholder.Kind)}
+		if check := placeholder.acceptCheck(); check != "" {
+			notes = append(notes, check)
+		}
+		if _, err := doc.Add(title, 0, notes...); err != nil {
+			return added, err
+		}
+		added++
+	}
+	if added == 0 {
+		return 0, nil
+	}
+	return added, doc.Save(TasksPath())
+}
+
+func findTask(doc *tasks.Document, title string) *tasks.Task {
+	for _, task := range doc.All() {
+		if task.Title == title {
+			return task
+		}
+	}
+	return nil
+}
+
+// acceptCheck returns the acceptance check that passes once the placeholder is gone, or ""
+// when the placeholder has no text to look for.
+func (p Placeholder) acceptCheck() string {
+	switch p.Kind {
+	case "todo", "elided", "not-implemented":
+		return fmt.Sprintf("%s !grep %s %s", AcceptPrefix, regexp.QuoteMeta(firstLine(p.Text)), p.Path)
+	case "syntax":
+		return fmt.Sprintf("%s gofmt -e -l %s", AcceptPrefix, p.Path)
+	}
+	return ""
+}
+
+// placeholderTitle does not include the line, which moves as the file is edited.
+func placeholderTitle(placeholder Placeholder) string {
+	switch placeholder.Kind {
+	case "syntax":
+		return fmt.Sprintf("Fix the syntax error in %s: %s", placeholder.Path, placeholder.Text)
+	case "empty-body", "stub":
+		return fmt.Sprintf("Implement %s: %s", placeholder.Path, placeholder.Text)
+	}
+	return fmt.Sprintf("Resolve the placeholder in %s: %s", placeholder.Path, placeholder.Text)
+}
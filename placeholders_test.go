@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const placeholderSource = `package server
+
+type node interface{ isNode() }
+
+type leaf struct{}
+
+func (leaf) isNode() {}
+
+// TODO: handle the errors
+func Serve() error {
+	// ... existing code ...
+	return nil
+}
+
+func Start() {}
+
+func Stop() {
+	// Nothing to release.
+}
+
+// Handler is a placeholder until the router exists.
+func Handler() (string, error) {
+	return "", nil
+}
+
+func Routes() []string {
+	panic("not implemented")
+}
+
+func Port() int {
+	return 0
+}
+
+func Close() error {
+	// rest of the code...
+	return nil
+}
+`
+
+func TestScanFile(t *testing.T) {
+	workingDirectory = t.TempDir()
+	os.WriteFile(filepath.Join(workingDirectory, "server.go"), []byte(placeholderSource), 0644)
+	os.WriteFile(filepath.Join(workingDirectory, "broken.go"), []byte("package server\n\nfunc Broken( {\n"), 0644)
+
+	placeholders, err := ScanFile("server.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, placeholder := range placeholders {
+		got = append(got, placeholder.String())
+	}
+	want := []string{
+		"server.go:9: todo: TODO: handle the errors",
+		"server.go:11: elided: ... existing code ...",
+		"server.go:15: empty-body: func Start has an empty body",
+		"server.go:22: stub: func Handler only returns zero values",
+		"server.go:27: not-implemented: panic(\"not implemented\")",
+		"server.go:35: elided: rest of the code...",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Unexpected placeholders:\n%s", strings.Join(got, "\n"))
+	}
+
+	placeholders, err = ScanFile("broken.go")
+	if err != nil || len(placeholders) != 1 || placeholders[0].Kind != "syntax" || placeholders[0].Line != 3 {
+		t.Errorf("Expected a syntax error on line 3, got %+v (%v)", placeholders, err)
+	}
+}
+
+func TestScanPlaceholders(t *testing.T) {
+	workingDirectory = t.TempDir()
+	initRepo(t)
+	os.WriteFile(filepath.Join(workingDirectory, "old.go"), []byte("package main\n\n// TODO: committed before the run\n"), 0644)
+	if _, err := WorkingRepo().Commit(context.Background(), "old"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the lines added to the changed and untracked Go files are scanned.
+	os.WriteFile(filepath.Join(workingDirectory, "old.go"), []byte("package main\n\n// TODO: committed before the run\n\n// TODO: added by the run\n"), 0644)
+	os.WriteFile(filepath.Join(workingDirectory, "new.go"), []byte("package main\n\n// FIXME: new\n"), 0644)
+	os.WriteFile(filepath.Join(workingDirectory, "notes.txt"), []byte("TODO\n"), 0644)
+	placeholders, err := ScanPlaceholders(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(placeholders) != 2 || placeholders[0].String() != "new.go:3: todo: FIXME: new" || placeholders[1].String() != "old.go:5: todo: TODO: added by the run" {
+		t.Fatalf("Unexpected placeholders: %+v", placeholders)
+	}
+
+	if added, err := addPlaceholderTasks(placeholders[:1]); err != nil || added != 1 {
+		t.Fatalf("Expected 1 task, got %d (%v)", added, err)
+	}
+	if added, _ := addPlaceholderTasks(placeholders[:1]); added != 0 {
+		t.Errorf("Expected the open task not to be added twice")
+	}
+	tasks, _ := os.ReadFile(TasksPath())
+	if string(tasks) != "- [ ] Resolve the placeholder in new.go: FIXME: new <!-- id:1 -->\n  new.go:3: todo\n  accept: !grep FIXME: new new.go\n" {
+		t.Errorf("Unexpected TASKS.md:\n%s", tasks)
+	}
+
+	// The acceptance check keeps the task open while the placeholder is there.
+	if result := CompleteTask(context.Background(), 1); !strings.Contains(result, "FIXME: new") {
+		t.Errorf("Expected the task to stay open while the placeholder is there, got %q", result)
+	}
+	// A finished task is not added again.
+	os.WriteFile(filepath.Join(workingDirectory, "new.go"), []byte("package main\n"), 0644)
+	if result := CompleteTask(context.Background(), 1); strings.HasPrefix(result, "Error") {
+		t.Fatalf("Expected the task to be completed, got %q", result)
+	}
+	if added, _ := addPlaceholderTasks(placeholders[:1]); added != 0 {
+		t.Errorf("Expected the done task not to be added again")
+	}
+}
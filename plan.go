@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"dev/tasks"
+)
+
+// A Plan is the dependency graph of tasks the planner makes out of INPUT.md. Once
+// submitted, its tasks are added to TASKS.md and its ids become the ids of the tasks.
+type Plan struct {
+	Tasks []PlanTask `json:"tasks"`
+}
+
+type PlanTask struct {
+	ID        int      `json:"id"`
+	Title     string   `json:"title"`
+	DependsOn []int    `json:"depends_on,omitempty"`
+	Size      string   `json:"size"` // s, m or l
+	Files     []string `json:"files,omitempty"`
+	Accept    []string `json:"accept,omitempty"`
+	Notes     []string `json:"notes,omitempty"`
+}
+
+var planSizes = []string{"s", "m", "l"}
+
+func PlanPath() string {
+	return filepath.Join(workingDirectory, ".dev", "plan.json")
+}
+
+func LoadPlan() (*Plan, error) {
+	content, err := os.ReadFile(PlanPath())
+	if err != nil {
+		return nil, err
+	}
+	var plan Plan
+	if err := json.Unmarshal(content, &plan); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", PlanPath(), err)
+	}
+	return &plan, nil
+}
+
+func (p *Plan) Save() error {
+	content, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(PlanPath()), 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(PlanPath(), append(content, '\n'), 0644)
+}
+
+func (p *Plan) find(id int) *PlanTask {
+	for i := range p.Tasks {
+		if p.Tasks[i].ID == id {
+			return &p.Tasks[i]
+		}
+	}
+	return nil
+}
+
+// Validate returns every problem of the plan: duplicate or dangling ids, cycles,
+// missing titles, unknown sizes and invalid acceptance checks.
+func (p *Plan) Validate() error {
+	if len(p.Tasks) == 0 {
+		return errors.New("the plan has no tasks")
+	}
+	var errs []error
+	seen := map[int]bool{}
+	for _, task := range p.Tasks {
+		switch {
+		case task.ID <= 0:
+			errs = append(errs, fmt.Errorf("task %q: the id must be a positive number", task.Title))
+		case seen[task.ID]:
+			errs = append(errs, fmt.Errorf("task %d: duplicate id", task.ID))
+		}
+		seen[task.ID] = true
+		if strings.TrimSpace(task.Title) == "" {
+			errs = append(errs, fmt.Errorf("task %d: missing title", task.ID))
+		}
+		if !contains(planSizes, task.Size) {
+			errs = append(errs, fmt.Errorf("task %d: size %q is not one of %s", task.ID, task.Size, strings.Join(planSizes, ", ")))
+		}
+		for _, accept := range task.Accept {
+			if _, _, err := ParseCheck(AcceptPrefix + " " + strings.TrimSpace(strings.TrimPrefix(accept, AcceptPrefix))); err != nil {
+				errs = append(errs, fmt.Errorf("task %d: %w", task.ID, err))
+			}
+		}
+	}
+	for _, task := range p.Tasks {
+		for _, dep := range task.DependsOn {
+			if p.find(dep) == nil {
+				errs = append(errs, fmt.Errorf("task %d: depends on task %d, which is not in the plan", task.ID, dep))
+			}
+		}
+	}
+	if cycle := p.cycle(); cycle != nil {
+		errs = append(errs, fmt.Errorf("cycle: %s", formatIDs(cycle, " -> ")))
+	}
+	return errors.Join(errs...)
+}
+
+// cycle returns the ids of a dependency cycle, the first one repeated at the end, or
+// nil when there is none.
+func (p *Plan) cycle() []int {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := map[int]int{}
+	var path []int
+	var visit func(id int) []int
+	visit = func(id int) []int {
+		switch state[id] {
+		case visiting:
+			for i, step := range path {
+				if step == id {
+					return append(append([]int{}, path[i:]...), id)
+				}
+			}
+		case visited:
+			return nil
+		}
+		state[id] = visiting
+		path = append(path, id)
+		if task := p.find(id); task != nil {
+			for _, dep := range task.DependsOn {
+				if cycle := visit(dep); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[id] = visited
+		return nil
+	}
+	for _, task := range p.Tasks {
+		if cycle := visit(task.ID); cycle != nil {
+			return cycle
+		}
+	}
+	return nil
+}
+
+// Waves groups the tasks of a valid plan in the order they can run: every task comes
+// after its dependencies, and the tasks of a wave can run at the same time.
+func (p *Plan) Waves() [][]PlanTask {
+	wave := map[int]int{}
+	var depth func(task PlanTask) int
+	depth = func(task PlanTask) int {
+		if d, ok := wave[task.ID]; ok {
+			return d
+		}
+		d := 0
+		for _, dep := range task.DependsOn {
+			if task := p.find(dep); task != nil {
+				d = max(d, depth(*task)+1)
+			}
+		}
+		wave[task.ID] = d
+		return d
+	}
+	var waves [][]PlanTask
+	for _, task := range p.Tasks {
+		d := depth(task)
+		for len(waves) <= d {
+			waves = append(waves, nil)
+		}
+		waves[d] = append(waves[d], task)
+	}
+	return waves
+}
+
+// Apply adds the tasks of a valid plan to TASKS.md in dependency order, and renumbers
+// the plan with the ids of the tasks.
+func (p *Plan) Apply(doc *tasks.Document) error {
+	ids := map[int]int{}
+	var applied []PlanTask
+	for _, wave := range p.Waves() {
+		for _, task := range wave {
+			var notes []string
+			if len(task.DependsOn) == 0 {
+				notes = append(notes, tasks.IndependentNote)
+			} else {
+				var deps []int
+				for _, dep := range task.DependsOn {
+					deps = append(deps, ids[dep])
+				}
+				notes = append(notes, tasks.DependsPrefix+" "+formatIDs(deps, ", "))
+			}
+			notes = append(notes, "size: "+task.Size)
+			if len(task.Files) > 0 {
+				notes = append(notes, "files: "+strings.Join(task.Files, ", "))
+			}
+			for _, accept := range task.Accept {
+				notes = append(notes, AcceptPrefix+" "+strings.TrimSpace(strings.TrimPrefix(accept, AcceptPrefix)))
+			}
+			notes = append(notes, task.Notes...)
+			added, err := doc.Add(task.Title, 0, notes...)
+			if err != nil {
+				return err
+			}
+			ids[task.ID] = added.ID
+			applied = append(applied, task)
+		}
+	}
+	for i := range applied {
+		applied[i].ID = ids[applied[i].ID]
+		for j, dep := range applied[i].DependsOn {
+			applied[i].DependsOn[j] = ids[dep]
+		}
+	}
+	p.Tasks = applied
+	return nil
+}
+
+// Format renders the plan for `dev plan`, wave by wave.
+func (p *Plan) Format() string {
+	var b strings.Builder
+	for i, wave := range p.Waves() {
+		ids := make([]int, len(wave))
+		for j, task := range wave {
+			ids[j] = task.ID
+		}
+		fmt.Fprintf(&b, "Wave %d: %s\n", i+1, formatIDs(ids, ", "))
+		for _, task := range wave {
+			fmt.Fprintf(&b, "  #%d [%s] %s\n", task.ID, task.Size, task.Title)
+			if len(task.DependsOn) > 0 {
+				fmt.Fprintf(&b, "      depends on: %s\n", formatIDs(task.DependsOn, ", "))
+			}
+			if len(task.Files) > 0 {
+				fmt.Fprintf(&b, "      files: %s\n", strings.Join(task.Files, ", "))
+			}
+			for _, accept := range task.Accept {
+				fmt.Fprintf(&b, "      accept: %s\n", strings.TrimSpace(strings.TrimPrefix(accept, AcceptPrefix)))
+			}
+		}
+	}
+	return b.String()
+}
+
+func formatIDs(ids []int, sep string) string {
+	s := make([]string, len(ids))
+	for i, id := range ids {
+		s[i] = strconv.Itoa(id)
+	}
+	return strings.Join(s, sep)
+}
+
+// SubmitPlan is the submit_plan tool: it validates the plan, adds its tasks to
+// TASKS.md and saves it to .dev/plan.json.
+func SubmitPlan(plan Plan) string {
+	if session.Phase != PhasePlan {
+		return "Error: the plan can only be submitted while planning, use add_task instead"
+	}
+	if session.Plan != nil {
+		return "Error: the plan was already submitted"
+	}
+	for i := range plan.Tasks {
+		plan.Tasks[i].Size = strings.ToLower(strings.TrimSpace(plan.Tasks[i].Size))
+	}
+	if err := plan.Validate(); err != nil {
+		return fmt.Sprintf("Error: the plan is not valid:\n%s\nFix it and submit the whole plan again.", err)
+	}
+	result := updateTasks(func(doc *tasks.Document) (string, error) {
+		if err := plan.Apply(doc); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Added %d tasks:\n%s", len(plan.Tasks), plan.Format()), nil
+	})
+	if strings.HasPrefix(result, "Error") {
+		return result
+	}
+	if err := plan.Save(); err != nil {
+		return fmt.Sprintf("Error writing %s: %s", PlanPath(), err)
+	}
+	session.Plan = &plan
+	return result
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestPlanValidate(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		plan Plan
+		want string
+	}{
+		{"empty", Plan{}, "the plan has no tasks"},
+		{"dangling", Plan{Tasks: []PlanTask{{ID: 1, Title: "A", Size: "s", DependsOn: []int{7}}}}, "task 1: depends on task 7, which is not in the plan"},
+		{"cycle", Plan{Tasks: []PlanTask{
+			{ID: 1, Title: "A", Size: "s"},
+			{ID: 2, Title: "B", Size: "s", DependsOn: []int{1, 3}},
+			{ID: 3, Title: "C", Size: "s", DependsOn: []int{2}},
+		}}, "cycle: 2 -> 3 -> 2"},
+		{"self", Plan{Tasks: []PlanTask{{ID: 1, Title: "A", Size: "s", DependsOn: []int{1}}}}, "cycle: 1 -> 1"},
+		{"duplicate", Plan{Tasks: []PlanTask{{ID: 1, Title: "A", Size: "s"}, {ID: 1, Title: "B", Size: "s"}}}, "task 1: duplicate id"},
+		{"size", Plan{Tasks: []PlanTask{{ID: 1, Title: "A", Size: "xl"}}}, `task 1: size "xl" is not one of s, m, l`},
+		{"accept", Plan{Tasks: []PlanTask{{ID: 1, Title: "A", Size: "s", Accept: []string{"grep ( main.go"}}}}, "task 1:"},
+	} {
+		err := test.plan.Validate()
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: expected %q, got %v", test.name, test.want, err)
+		}
+	}
+
+	valid := Plan{Tasks: []PlanTask{{ID: 1, Title: "A", Size: "s"}, {ID: 2, Title: "B", Size: "m", DependsOn: []int{1}}}}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("Expected a valid plan, got %v", err)
+	}
+}
+
+func TestPlanTasks(t *testing.T) {
+	const model = "test-model"
+	cyclic := []map[string]any{
+		{"id": 1, "title": "Write the handler", "size": "m", "depends_on": []int{2}},
+		{"id": 2, "title": "Define the routes", "size": "s", "depends_on": []int{1}},
+	}
+	valid := []map[string]any{
+		{"id": 10, "title": "Write the handler", "size": "m", "depends_on": []int{20}, "files": []string{"handler.go"}, "accept": []string{"exists handler.go"}},
+		{"id": 20, "title": "Define the routes", "size": "s", "files": []string{"routes.go"}},
+		{"id": 30, "title": "Document the API", "size": "S"},
+	}
+	cassette := &Cassette{Interactions: []Interaction{
+		toolCallInteraction(model, call("submit_plan", map[string]any{"tasks": cyclic})),
+		toolCallInteraction(model, call("submit_plan", map[string]any{"tasks": valid})),
+		textInteraction(model, "Planned."),
+	}}
+	replay := setupRun(t, "Add an API.\n", cassette)
+
+	if err := planTasks(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if replay.Remaining() != 0 {
+		t.Errorf("Expected the whole cassette to be replayed, %d interactions left", replay.Remaining())
+	}
+	if result := replay.Received[1].Messages[len(replay.Received[1].Messages)-1].Content; !strings.Contains(result, "cycle: 1 -> 2 -> 1") {
+		t.Errorf("Expected the cycle to be reported to the planner, got %q", result)
+	}
+
+	content, _ := os.ReadFile(TasksPath())
+	want := `- [ ] Define the routes <!-- id:1 -->
+  independent
+  size: s
+  files: routes.go
+- [ ] Document the API <!-- id:2 -->
+  independent
+  size: s
+- [ ] Write the handler <!-- id:3 -->
+  depends: 1
+  size: m
+  files: handler.go
+  accept: exists handler.go
+`
+	if string(content) != want {
+		t.Errorf("Unexpected TASKS.md:\n%s", content)
+	}
+	plan, err := LoadPlan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantPlan := `Wave 1: 1, 2
+  #1 [s] Define the routes
+      files: routes.go
+  #2 [s] Document the API
+Wave 2: 3
+  #3 [m] Write the handler
+      depends on: 1
+      files: handler.go
+      accept: exists handler.go
+`
+	if got := plan.Format(); got != wantPlan {
+		t.Errorf("Unexpected plan:\n%s", got)
+	}
+	if session.Phase != PhaseTask || session.Plan == nil {
+		t.Errorf("Expected the run to move to its tasks with the plan, got phase %s", session.Phase)
+	}
+	if result := SubmitPlan(Plan{Tasks: []PlanTask{{ID: 1, Title: "A", Size: "s"}}}); !strings.HasPrefix(result, "Error") {
+		t.Errorf("Expected the plan to be refused outside of planning, got %q", result)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+const BaseSystemPrompt = `You are an autonomous software engineer working on the project in the working directory.
+You only act through the tools you are given; paths are relative to the working directory.
+
+- Work on one task at a time and track it in TASKS.md with the task tools, not write_file.
+- Read the relevant code before changing it. Use read_code and add_or_edit_function for Go files.
+- Keep changes small and consistent with the existing code, and lint the Go files you touch.
+- Do not leave TODOs, placeholders or partial implementations.
+- The project instructions below take precedence over these rules.`
+
+// InstructionFiles are read from the working directory, in order, and added to the system prompt.
+var InstructionFiles = []string{"AGENTS.md", filepath.Join(".dev", "instructions.md")}
+
+// MaxPackagesInPrompt bounds the package list of the environment section.
+const MaxPackagesInPrompt = 100
+
+// SystemMessage builds the system prompt that starts every conversation: the base
+// prompt, the project instructions and a description of the environment.
+func SystemMessage(ctx context.Context) openai.ChatCompletionMessage {
+	sections := []string{BaseSystemPrompt}
+	if instructions := ProjectInstructions(); instructions != "" {
+		sections = append(sections, "# Project instructions\n\n"+instructions)
+	}
+	sections = append(sections, Environment(ctx))
+	return openai.ChatCompletionMessage{
+		Role:    openai.ChatMessageRoleSystem,
+		Content: strings.Join(sections, "\n\n"),
+	}
+}
+
+func ProjectInstructions() string {
+	var instructions []string
+	for _, name := range InstructionFiles {
+		content, err := os.ReadFile(filepath.Join(workingDirectory, name))
+		if err != nil || strings.TrimSpace(string(content)) == "" {
+			continue
+		}
+		instructions = append(instructions, strings.TrimSpace(string(content)))
+	}
+	return strings.Join(instructions, "\n\n")
+}
+
+// Environment describes the working directory: Go version, module path and packages.
+func Environment(ctx context.Context) string {
+	var env strings.Builder
+	env.WriteString("# Environment\n\n")
+	env.WriteString(fmt.Sprintf("- Working directory: %s\n", workingDirectory))
+
+	command := exec.CommandContext(ctx, "go", "env", "GOVERSION")
+	command.Dir = workingDirectory
+	if output, err := command.Output(); err == nil {
+		env.WriteString(fmt.Sprintf("- Go version: %s\n", strings.TrimSpace(string(output))))
+	}
+
+	module := modulePath()
+	if module == "" {
+		env.WriteString("- No go.mod in the working directory\n")
+		return env.String()
+	}
+	env.WriteString(fmt.Sprintf("- Module: %s\n", module))
+
+	command = exec.CommandContext(ctx, "go", "list", "./...")
+	command.Dir = workingDirectory
+	output, err := command.Output()
+	if err != nil {
+		return env.String()
+	}
+	packages := strings.Fields(string(output))
+	env.WriteString(fmt.Sprintf("- Packages (%d):\n", len(packages)))
+	for i, pkg := range packages {
+		if i == MaxPackagesInPrompt {
+			env.WriteString(fmt.Sprintf("  - ... and %d more\n", len(packages)-i))
+			break
+		}
+		env.WriteString(fmt.Sprintf("  - %s\n", pkg))
+	}
+	return env.String()
+}
+
+// modulePath returns the module path declared in the go.mod of the working directory.
+func modulePath() string {
+	content, err := os.ReadFile(filepath.Join(workingDirectory, "go.mod"))
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "module ") {
+			return strings.Trim(strings.TrimSpace(strings.TrimPrefix(line, "module")), `"`)
+		}
+	}
+	return ""
+}
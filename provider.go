@@ -0,0 +1,399 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// Provider is a chat completion backend. Requests and responses use the
+// OpenAI chat types so the rest of the agent does not care which API is behind it.
+type Provider interface {
+	CreateChatCompletion(ctx context.Context, request openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error)
+	Capabilities() Capabilities
+}
+
+// Capabilities describes what a provider supports.
+type Capabilities struct {
+	ToolChoice bool // forcing a specific tool with tool_choice
+}
+
+var provider Provider
+
+const (
+	OpenRouterBaseURL = "https://openrouter.ai/api/v1"
+	AnthropicBaseURL  = "https://api.anthropic.com/v1"
+	AnthropicVersion  = "2023-06-01"
+)
+
+// NewProvider builds a provider by name (openrouter, openai or anthropic). Empty baseURL
+// or apiKey fall back to the provider defaults and environment variables.
+func NewProvider(name string, baseURL string, apiKey string) (Provider, error) {
+	switch name {
+	case "openrouter":
+		if apiKey == "" {
+			apiKey = os.Getenv("OPENROUTER_API_KEY")
+		}
+		if apiKey == "" {
+			return nil, fmt.Errorf("OPENROUTER_API_KEY is not set")
+		}
+		if baseURL == "" {
+			baseURL = OpenRouterBaseURL
+		}
+		return NewOpenAICompatibleProvider(baseURL, apiKey), nil
+	case "openai":
+		if apiKey == "" {
+			apiKey = os.Getenv("OPENAI_API_KEY")
+		}
+		if baseURL == "" {
+			baseURL = os.Getenv("OPENAI_BASE_URL")
+		}
+		if baseURL == "" {
+			return nil, fmt.Errorf("a base URL is required for the openai provider (DEV_BASE_URL or OPENAI_BASE_URL)")
+		}
+		return NewOpenAICompatibleProvider(baseURL, apiKey), nil
+	case "anthropic":
+		if apiKey == "" {
+			apiKey = os.Getenv("ANTHROPIC_API_KEY")
+		}
+		if apiKey == "" {
+			return nil, fmt.Errorf("ANTHROPIC_API_KEY is not set")
+		}
+		if baseURL == "" {
+			baseURL = AnthropicBaseURL
+		}
+		return NewAnthropicProvider(baseURL, apiKey), nil
+	}
+	return nil, fmt.Errorf("unknown provider %q", name)
+}
+
+// OpenAICompatibleProvider talks to any server implementing the OpenAI chat
+// completions API: OpenRouter, vLLM, llama.cpp, Ollama, etc.
+type OpenAICompatibleProvider struct {
+	client *openai.Client
+	// ToolChoice is false for servers that cannot force a tool, see Config.ToolChoice.
+	ToolChoice bool
+}
+
+func NewOpenAICompatibleProvider(baseURL string, apiKey string) *OpenAICompatibleProvider {
+	clientConfig := openai.DefaultConfig(apiKey)
+	clientConfig.BaseURL = strings.TrimSuffix(baseURL, "/")
+	clientConfig.HTTPClient = retryAfterDoer{client: &http.Client{}}
+	return &OpenAICompatibleProvider{client: openai.NewClientWithConfig(clientConfig), ToolChoice: true}
+}
+
+func (p *OpenAICompatibleProvider) CreateChatCompletion(ctx context.Context, request openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	var wait time.Duration
+	response, err := p.client.CreateChatCompletion(context.WithValue(ctx, retryAfterKey{}, &wait), request)
+	if err != nil {
+		statusCode := 0
+		var apiErr *openai.APIError
+		var requestErr *openai.RequestError
+		switch {
+		case errors.As(err, &apiErr):
+			statusCode = apiErr.HTTPStatusCode
+		case errors.As(err, &requestErr):
+			statusCode = requestErr.HTTPStatusCode
+		}
+		if statusCode != 0 {
+			return response, &HTTPError{StatusCode: statusCode, RetryAfter: wait, Err: err}
+		}
+	}
+	return response, err
+}
+
+func (p *OpenAICompatibleProvider) Capabilities() Capabilities {
+	return Capabilities{ToolChoice: p.ToolChoice}
+}
+
+type retryAfterKey struct{}
+
+// retryAfterDoer records the Retry-After header of error responses into the
+// *time.Duration stored in the request context, since the openai client drops headers.
+type retryAfterDoer struct {
+	client *http.Client
+}
+
+func (d retryAfterDoer) Do(req *http.Request) (*http.Response, error) {
+	resp, err := d.client.Do(req)
+	if err == nil && resp.StatusCode >= 400 {
+		if wait, ok := req.Context().Value(retryAfterKey{}).(*time.Duration); ok {
+			*wait = parseRetryAfter(resp.Header.Get("Retry-After"))
+		}
+	}
+	return resp, err
+}
+
+// AnthropicProvider talks to the native Anthropic messages API, translating
+// from and to the OpenAI chat types.
+type AnthropicProvider struct {
+	baseURL    string
+	apiKey     string
+	httpClient *http.Client
+}
+
+func NewAnthropicProvider(baseURL string, apiKey string) *AnthropicProvider {
+	return &AnthropicProvider{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		apiKey:     apiKey,
+		httpClient: &http.Client{},
+	}
+}
+
+func (p *AnthropicProvider) Capabilities() Capabilities {
+	return Capabilities{ToolChoice: true}
+}
+
+type anthropicRequest struct {
+	Model       string             `json:"model"`
+	System      string             `json:"system,omitempty"`
+	Messages    []anthropicMessage `json:"messages"`
+	MaxTokens   int                `json:"max_tokens"`
+	Temperature *float32           `json:"temperature,omitempty"`
+	Tools       []anthropicTool    `json:"tools,omitempty"`
+	ToolChoice  any                `json:"tool_choice,omitempty"`
+}
+
+type anthropicMessage struct {
+	Role    string           `json:"role"`
+	Content []anthropicBlock `json:"content"`
+}
+
+type anthropicBlock struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text,omitempty"`
+	ID        string          `json:"id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Input     json.RawMessage `json:"input,omitempty"`
+	ToolUseID string          `json:"tool_use_id,omitempty"`
+	Content   string          `json:"content,omitempty"`
+}
+
+type anthropicTool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	InputSchema json.RawMessage `json:"input_schema"`
+}
+
+type anthropicResponse struct {
+	ID         string           `json:"id"`
+	Model      string           `json:"model"`
+	Content    []anthropicBlock `json:"content"`
+	StopReason string           `json:"stop_reason"`
+	Usage      struct {
+		InputTokens  int `json:"input_tokens"`
+		OutputTokens int `json:"output_tokens"`
+	} `json:"usage"`
+}
+
+func (p *AnthropicProvider) CreateChatCompletion(ctx context.Context, request openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	body, err := json.Marshal(toAnthropicRequest(request))
+	if err != nil {
+		return openai.ChatCompletionResponse{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL+"/messages", bytes.NewReader(body))
+	if err != nil {
+		return openai.ChatCompletionResponse{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-api-key", p.apiKey)
+	req.Header.Set("anthropic-version", AnthropicVersion)
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return openai.ChatCompletionResponse{}, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return openai.ChatCompletionResponse{}, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return openai.ChatCompletionResponse{}, &HTTPError{
+			StatusCode: resp.StatusCode,
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+			Err:        fmt.Errorf("anthropic: %s", strings.TrimSpace(string(data))),
+		}
+	}
+
+	var response anthropicResponse
+	if err := json.Unmarshal(data, &response); err != nil {
+		return openai.ChatCompletionResponse{}, fmt.Errorf("anthropic: decoding response: %w", err)
+	}
+	return fromAnthropicResponse(response), nil
+}
+
+// requestTemperature is the temperature of a request for the one of a model config.
+// go-openai leaves out a temperature of 0, so without one it is 0 and the provider
+// default is used, and an explicit 0 is sent as the smallest float32 instead.
+func requestTemperature(temperature *float32) float32 {
+	switch {
+	case temperature == nil:
+		return 0
+	case *temperature == 0:
+		return math.SmallestNonzeroFloat32
+	}
+	return *temperature
+}
+
+func toAnthropicRequest(request openai.ChatCompletionRequest) anthropicRequest {
+	out := anthropicRequest{
+		Model:     request.Model,
+		MaxTokens: request.MaxTokens,
+	}
+	// Like go-openai, a temperature of 0 is left out, see requestTemperature.
+	if request.Temperature != 0 {
+		temperature := request.Temperature
+		if temperature == math.SmallestNonzeroFloat32 {
+			temperature = 0
+		}
+		out.Temperature = &temperature
+	}
+	if request.MaxCompletionTokens > 0 {
+		out.MaxTokens = request.MaxCompletionTokens
+	}
+	if out.MaxTokens == 0 {
+		out.MaxTokens = 4096
+	}
+
+	var system []string
+	for _, message := range request.Messages {
+		var role string
+		var blocks []anthropicBlock
+		switch message.Role {
+		case openai.ChatMessageRoleSystem, openai.ChatMessageRoleDeveloper:
+			system = append(system, message.Content)
+			continue
+		case openai.ChatMessageRoleTool:
+			role = "user"
+			blocks = []anthropicBlock{{Type: "tool_result", ToolUseID: message.ToolCallID, Content: message.Content}}
+		case openai.ChatMessageRoleAssistant:
+			role = "assistant"
+			if message.Content != "" {
+				blocks = append(blocks, anthropicBlock{Type: "text", Text: message.Content})
+			}
+			for _, toolCall := range message.ToolCalls {
+				input := json.RawMessage(toolCall.Function.Arguments)
+				if !json.Valid(input) {
+					input = json.RawMessage("{}")
+				}
+				blocks = append(blocks, anthropicBlock{Type: "tool_use", ID: toolCall.ID, Name: toolCall.Function.Name, Input: input})
+			}
+		default:
+			role = "user"
+			blocks = []anthropicBlock{{Type: "text", Text: message.Content}}
+		}
+		if len(blocks) == 0 {
+			continue
+		}
+		// The messages API expects alternating roles, so merge consecutive turns.
+		if n := len(out.Messages); n > 0 && out.Messages[n-1].Role == role {
+			out.Messages[n-1].Content = append(out.Messages[n-1].Content, blocks...)
+			continue
+		}
+		out.Messages = append(out.Messages, anthropicMessage{Role: role, Content: blocks})
+	}
+	out.System = strings.Join(system, "\n\n")
+
+	for _, tool := range request.Tools {
+		if tool.Function == nil {
+			continue
+		}
+		out.Tools = append(out.Tools, anthropicTool{
+			Name:        tool.Function.Name,
+			Description: tool.Function.Description,
+			InputSchema: anthropicSchema(tool.Function.Parameters),
+		})
+	}
+
+	switch choice := request.ToolChoice.(type) {
+	case openai.ToolChoice:
+		out.ToolChoice = map[string]string{"type": "tool", "name": choice.Function.Name}
+	case *openai.ToolChoice:
+		out.ToolChoice = map[string]string{"type": "tool", "name": choice.Function.Name}
+	case string:
+		switch choice {
+		case "required":
+			out.ToolChoice = map[string]string{"type": "any"}
+		case "auto":
+			out.ToolChoice = map[string]string{"type": "auto"}
+		}
+	}
+	return out
+}
+
+// anthropicSchema converts tool parameters to an input schema. Anthropic requires an
+// object schema with properties even for tools without arguments.
+func anthropicSchema(parameters any) json.RawMessage {
+	schema := map[string]any{}
+	if parameters != nil {
+		data, err := json.Marshal(parameters)
+		if err == nil {
+			json.Unmarshal(data, &schema)
+		}
+	}
+	if schema["type"] == nil {
+		schema["type"] = "object"
+	}
+	if schema["properties"] == nil {
+		schema["properties"] = map[string]any{}
+	}
+	data, _ := json.Marshal(schema)
+	return data
+}
+
+func fromAnthropicResponse(response anthropicResponse) openai.ChatCompletionResponse {
+	message := openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant}
+	var text []string
+	for _, block := range response.Content {
+		switch block.Type {
+		case "text":
+			text = append(text, block.Text)
+		case "tool_use":
+			arguments := string(block.Input)
+			if arguments == "" {
+				arguments = "{}"
+			}
+			message.ToolCalls = append(message.ToolCalls, openai.ToolCall{
+				ID:   block.ID,
+				Type: openai.ToolTypeFunction,
+				Function: openai.FunctionCall{
+					Name:      block.Name,
+					Arguments: arguments,
+				},
+			})
+		}
+	}
+	message.Content = strings.Join(text, "")
+
+	finishReason := openai.FinishReasonStop
+	switch response.StopReason {
+	case "tool_use":
+		finishReason = openai.FinishReasonToolCalls
+	case "max_tokens":
+		finishReason = openai.FinishReasonLength
+	}
+
+	return openai.ChatCompletionResponse{
+		ID:    response.ID,
+		Model: response.Model,
+		Choices: []openai.ChatCompletionChoice{
+			{Index: 0, Message: message, FinishReason: finishReason},
+		},
+		Usage: openai.Usage{
+			PromptTokens:     response.Usage.InputTokens,
+			CompletionTokens: response.Usage.OutputTokens,
+			TotalTokens:      response.Usage.InputTokens + response.Usage.OutputTokens,
+		},
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestOpenAICompatibleProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			t.Errorf("Unexpected path: %s", r.URL.Path)
+		}
+		var request openai.ChatCompletionRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Fatalf("Failed to decode request: %v", err)
+		}
+		if request.Model != "local-model" {
+			t.Errorf("Expected model local-model, got %s", request.Model)
+		}
+		json.NewEncoder(w).Encode(openai.ChatCompletionResponse{
+			Choices: []openai.ChatCompletionChoice{
+				{Message: openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: "hello"}},
+			},
+		})
+	}))
+	defer server.Close()
+
+	p := NewOpenAICompatibleProvider(server.URL+"/v1", "")
+	response, err := p.CreateChatCompletion(context.Background(), openai.ChatCompletionRequest{
+		Model:    "local-model",
+		Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "hi"}},
+	})
+	if err != nil {
+		t.Fatalf("CreateChatCompletion failed: %v", err)
+	}
+	if response.Choices[0].Message.Content != "hello" {
+		t.Errorf("Expected content 'hello', got: %q", response.Choices[0].Message.Content)
+	}
+}
+
+func TestAnthropicProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/messages" {
+			t.Errorf("Unexpected path: %s", r.URL.Path)
+		}
+		if r.Header.Get("x-api-key") != "test-key" {
+			t.Errorf("Expected api key header, got %q", r.Header.Get("x-api-key"))
+		}
+		var request anthropicRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Fatalf("Failed to decode request: %v", err)
+		}
+		if request.Temperature != nil {
+			t.Errorf("Expected no temperature without one in the request, got %v", *request.Temperature)
+		}
+		if request.System != "be brief" {
+			t.Errorf("Expected system prompt to be lifted, got %q", request.System)
+		}
+		// user, assistant(tool_use), user(tool_result + text)
+		if len(request.Messages) != 3 {
+			t.Fatalf("Expected 3 merged messages, got %d: %+v", len(request.Messages), request.Messages)
+		}
+		if request.Messages[1].Content[0].Type != "tool_use" {
+			t.Errorf("Expected tool_use block, got %+v", request.Messages[1].Content)
+		}
+		if request.Messages[2].Content[0].Type != "tool_result" || request.Messages[2].Content[0].ToolUseID != "call_1" {
+			t.Errorf("Expected tool_result block, got %+v", request.Messages[2].Content)
+		}
+		if len(request.Tools) != 1 || string(request.Tools[0].InputSchema) != `{"properties":{},"type":"object"}` {
+			t.Errorf("Unexpected tools: %+v", request.Tools)
+		}
+
+		w.Write([]byte(`{
+			"id": "msg_1",
+			"model": "claude",
+			"stop_reason": "tool_use",
+			"content": [
+				{"type": "text", "text": "Reading"},
+				{"type": "tool_use", "id": "call_2", "name": "read_file", "input": {"path": "INPUT.md"}}
+			],
+			"usage": {"input_tokens": 10, "output_tokens": 5}
+		}`))
+	}))
+	defer server.Close()
+
+	p := NewAnthropicProvider(server.URL+"/v1", "test-key")
+	response, err := p.CreateChatCompletion(context.Background(), openai.ChatCompletionRequest{
+		Model: "claude",
+		Messages: []openai.ChatCompletionMessage{
+			{Role: openai.ChatMessageRoleSystem, Content: "be brief"},
+			{Role: openai.ChatMessageRoleUser, Content: "do it"},
+			{Role: openai.ChatMessageRoleAssistant, ToolCalls: []openai.ToolCall{
+				{ID: "call_1", Type: openai.ToolTypeFunction, Function: openai.FunctionCall{Name: "finished"}},
+			}},
+			{Role: openai.ChatMessageRoleTool, ToolCallID: "call_1", Content: "ok"},
+			{Role: openai.ChatMessageRoleUser, Content: "next"},
+		},
+		Tools: []openai.Tool{
+			{Type: openai.ToolTypeFunction, Function: &openai.FunctionDefinition{Name: "finished"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("CreateChatCompletion failed: %v", err)
+	}
+
+	message := response.Choices[0].Message
+	if message.Content != "Reading" {
+		t.Errorf("Expected content 'Reading', got %q", message.Content)
+	}
+	if len(message.ToolCalls) != 1 || message.ToolCalls[0].Function.Name != "read_file" || message.ToolCalls[0].Function.Arguments != `{"path": "INPUT.md"}` {
+		t.Errorf("Unexpected tool calls: %+v", message.ToolCalls)
+	}
+	if response.Choices[0].FinishReason != openai.FinishReasonToolCalls {
+		t.Errorf("Expected finish reason tool_calls, got %s", response.Choices[0].FinishReason)
+	}
+	if response.Usage.TotalTokens != 15 {
+		t.Errorf("Expected 15 total tokens, got %d", response.Usage.TotalTokens)
+	}
+}
+
+func TestRequestTemperature(t *testing.T) {
+	zero, warm := float32(0), float32(0.7)
+	for _, tt := range []struct {
+		name        string
+		temperature *float32
+		// The temperatures sent, "" for none. go-openai drops a 0, the smallest float32
+		// stands for it.
+		openAI, anthropic string
+	}{
+		{"unset", nil, "", ""},
+		{"zero", &zero, "1e-45", "0"},
+		{"set", &warm, "0.7", "0.7"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			request := openai.ChatCompletionRequest{Model: "model", Temperature: requestTemperature(tt.temperature)}
+
+			data, err := json.Marshal(request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var sent struct {
+				Temperature *float32 `json:"temperature"`
+			}
+			json.Unmarshal(data, &sent)
+			if got := formatTemperature(sent.Temperature); got != tt.openAI {
+				t.Errorf("OpenAI: expected temperature %q, got %q", tt.openAI, got)
+			}
+			if got := formatTemperature(toAnthropicRequest(request).Temperature); got != tt.anthropic {
+				t.Errorf("Anthropic: expected temperature %q, got %q", tt.anthropic, got)
+			}
+		})
+	}
+}
+
+func formatTemperature(temperature *float32) string {
+	if temperature == nil {
+		return ""
+	}
+	return strconv.FormatFloat(float64(*temperature), 'g', -1, 32)
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"dev/tasks"
+)
+
+// A CommandRecord is a command run for the agent: by a tool, an acceptance check or a gate.
+type CommandRecord struct {
+	Time     time.Time     `json:"time"`
+	Source   string        `json:"source"` // lint_file, accept or gate
+	Command  string        `json:"command"`
+	Passed   bool          `json:"passed"`
+	ExitCode int           `json:"exit_code"` // -1 when the command did not run or was killed
+	Output   string        `json:"output,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+// MaxCommandOutputChars bounds the output kept for every command, its end is kept.
+const MaxCommandOutputChars = 2000
+
+var commandsMu sync.Mutex
+
+// recordCommand adds a command that ran with err to the session for the run report.
+func recordCommand(source, command string, passed bool, output string, err error, start time.Time) {
+	if session == nil {
+		return
+	}
+	exitCode := 0
+	if err != nil {
+		exitCode = -1
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			exitCode = exitErr.ExitCode()
+		}
+	}
+	commandsMu.Lock()
+	defer commandsMu.Unlock()
+	session.Commands = append(session.Commands, CommandRecord{
+		Time:     start,
+		Source:   source,
+		Command:  command,
+		Passed:   passed,
+		ExitCode: exitCode,
+		Output:   lastChars(strings.TrimSpace(output), MaxCommandOutputChars),
+		Duration: time.Since(start),
+	})
+}
+
+var testCommand = regexp.MustCompile(`\b(go|npm|yarn|pnpm|cargo|make) test\b|\bpytest\b`)
+
+// RunReport is what happened during a run, written to .dev/reports at the end of it.
+type RunReport struct {
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	// Outcome is done, or why the run stopped.
+	Outcome    string           `json:"outcome"`
+	Input      string           `json:"input"`
+	Tasks      []ReportTask     `json:"tasks"`
+	Files      []FileChange     `json:"files"`
+	DiffStat   string           `json:"diff_stat,omitempty"`
+	Commands   []CommandRecord  `json:"commands"`
+	Gates      []GateResult     `json:"gates"`
+	Usage      Usage            `json:"usage"`
+	Models     map[string]Usage `json:"models"`
+	Unresolved []string         `json:"unresolved"`
+}
+
+type ReportTask struct {
+	ID       int    `json:"id"`
+	Title    string `json:"title"`
+	State    string `json:"state"`
+	Attempts int    `json:"attempts,omitempty"`
+	Commit   string `json:"commit,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+type FileChange struct {
+	Path    string `json:"path"`
+	Added   int    `json:"added"`
+	Deleted int    `json:"deleted"`
+	Binary  bool   `json:"binary,omitempty"`
+}
+
+// NewRunReport collects the report of the run, which ended with err.
+func NewRunReport(ctx context.Context, err error) *RunReport {
+	report := &RunReport{
+		StartedAt:  session.StartedAt,
+		FinishedAt: time.Now(),
+		Outcome:    "done",
+		Input:      session.Input,
+		Commands:   session.Commands,
+		Gates:      session.Gates,
+		Usage:      usage.Run,
+		Models:     usage.Models,
+	}
+	if err != nil {
+		report.Outcome = fmt.Sprintf("stopped in the %s phase: %s", session.Phase, err)
+	}
+
+	if doc, err := LoadTasks(); err != nil {
+		log.Printf("Error reading TASKS.md for the report: %s", err)
+	} else {
+		for _, task := range doc.All() {
+			meta := doc.Meta(task.ID)
+			reason := meta.Reason
+			if task.State == tasks.Failed {
+				reason = meta.LastError
+			}
+			report.Tasks = append(report.Tasks, ReportTask{ID: task.ID, Title: task.Title, State: task.State.String(), Attempts: meta.Attempts, Commit: meta.Commit, Reason: reason})
+			if task.State != tasks.Done && task.State != tasks.Skipped {
+				item := fmt.Sprintf("Task #%d %s: %s", task.ID, task.State, task.Title)
+				if reason != "" {
+					item += " (" + firstLine(reason) + ")"
+				}
+				report.Unresolved = append(report.Unresolved, item)
+			}
+		}
+	}
+	if session.Verdict != nil && !session.Verdict.Answer {
+		for _, item := range session.Verdict.Unmet {
+			report.Unresolved = append(report.Unresolved, "Review: "+item)
+		}
+	}
+	for _, result := range session.Gates {
+		if !result.Passed {
+			report.Unresolved = append(report.Unresolved, fmt.Sprintf("Gate %s fails", result.Gate.Name))
+		}
+	}
+
+	if session.StartSnapshot != "" {
+		if err := report.diff(ctx, session.StartSnapshot); err != nil {
+			log.Printf("Error listing the changed files for the report: %s", err)
+		}
+	}
+	return report
+}
+
+// diff lists the files changed since the snapshot taken when the run started.
+func (r *RunReport) diff(ctx context.Context, start string) error {
+	repo, cleanup, err := snapshotRepo(ctx)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	end, err := repo.WriteTree(ctx, snapshotPaths()...)
+	if err != nil {
+		return err
+	}
+	numstat, err := repo.git(ctx, "diff", "--numstat", start, end)
+	if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(strings.TrimSpace(numstat), "\n") {
+		fields := strings.SplitN(line, "\t", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		change := FileChange{Path: fields[2], Binary: fields[0] == "-"}
+		change.Added, _ = strconv.Atoi(fields[0])
+		change.Deleted, _ = strconv.Atoi(fields[1])
+		r.Files = append(r.Files, change)
+	}
+	r.DiffStat, err = repo.git(ctx, "diff", "--stat", start, end)
+	return err
+}
+
+func (r *RunReport) Markdown() string {
+	var b strings.Builder
+	b.WriteString("# Run report\n\n")
+	fmt.Fprintf(&b, "- Started: %s\n", r.StartedAt.Format(time.RFC3339))
+	fmt.Fprintf(&b, "- Finished: %s (%s)\n", r.FinishedAt.Format(time.RFC3339), r.FinishedAt.Sub(r.StartedAt).Round(time.Second))
+	fmt.Fprintf(&b, "- Outcome: %s\n", r.Outcome)
+	fmt.Fprintf(&b, "- Model calls: %d, tokens: %d, cost: $%.4f\n", r.Usage.Calls, r.Usage.TotalTokens(), r.Usage.Cost)
+
+	fmt.Fprintf(&b, "\n## Input\n\n%s\n", quote(r.Input))
+
+	b.WriteString("\n## Tasks\n\n")
+	if len(r.Tasks) == 0 {
+		b.WriteString("No tasks.\n")
+	} else {
+		b.WriteString("| Task | State | Attempts | Commit | Reason |\n|---|---|---|---|---|\n")
+		for _, task := range r.Tasks {
+			commit := task.Commit
+			if len(commit) > 12 {
+				commit = commit[:12]
+			}
+			fmt.Fprintf(&b, "| #%d %s | %s | %d | %s | %s |\n", task.ID, cell(task.Title), task.State, task.Attempts, commit, cell(firstLine(task.Reason)))
+		}
+	}
+
+	b.WriteString("\n## Files changed\n\n")
+	if len(r.Files) == 0 {
+		b.WriteString("No files changed.\n")
+	} else {
+		fmt.Fprintf(&b, "```\n%s\n```\n", strings.TrimRight(r.DiffStat, "\n"))
+	}
+
+	b.WriteString("\n## Commands\n\n")
+	if len(r.Commands) == 0 {
+		b.WriteString("No commands run.\n")
+	} else {
+		tests, failed := 0, 0
+		for _, command := range r.Commands {
+			if testCommand.MatchString(command.Command) {
+				tests++
+				if !command.Passed {
+					failed++
+				}
+			}
+		}
+		fmt.Fprintf(&b, "%d commands, %d test runs, %d of them failed.\n\n", len(r.Commands), tests, failed)
+		b.WriteString("| Source | Command | Result | Duration |\n|---|---|---|---|\n")
+		for _, command := range r.Commands {
+			result := "passed"
+			if !command.Passed {
+				result = fmt.Sprintf("failed (exit %d)", command.ExitCode)
+			}
+			fmt.Fprintf(&b, "| %s | `%s` | %s | %s |\n", command.Source, cell(command.Command), result, command.Duration.Round(time.Millisecond))
+		}
+	}
+
+	b.WriteString("\n## Gates\n\n")
+	if len(r.Gates) == 0 {
+		b.WriteString("The gates did not run.\n")
+	} else {
+		for _, result := range r.Gates {
+			status := "failed"
+			switch {
+			case result.Skipped:
+				status = "skipped"
+			case result.Passed:
+				status = "passed"
+			}
+			fmt.Fprintf(&b, "- %s: %s\n", result.Gate.Name, status)
+		}
+	}
+
+	b.WriteString("\n## Usage\n\n| Model | Calls | Prompt tokens | Completion tokens | Cost |\n|---|---|---|---|---|\n")
+	for _, model := range sortedKeys(r.Models) {
+		u := r.Models[model]
+		fmt.Fprintf(&b, "| %s | %d | %d | %d | $%.4f |\n", model, u.Calls, u.PromptTokens, u.CompletionTokens, u.Cost)
+	}
+
+	b.WriteString("\n## Unresolved\n\n")
+	if len(r.Unresolved) == 0 {
+		b.WriteString("Nothing.\n")
+	}
+	for _, item := range r.Unresolved {
+		fmt.Fprintf(&b, "- %s\n", item)
+	}
+	return b.String()
+}
+
+// Write writes the report to .dev/reports/<start of the run>.md and its JSON twin, and
+// returns the path of the markdown.
+func (r *RunReport) Write() (string, error) {
+	dir := filepath.Join(workingDirectory, ".dev", "reports")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	name := filepath.Join(dir, r.StartedAt.Format("20060102-150405"))
+	content, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(name+".json", append(content, '\n'), 0644); err != nil {
+		return "", err
+	}
+	return name + ".md", os.WriteFile(name+".md", []byte(r.Markdown()), 0644)
+}
+
+// LatestReport returns the path of the markdown report of the last run.
+func LatestReport() (string, error) {
+	reports, err := filepath.Glob(filepath.Join(workingDirectory, ".dev", "reports", "*.md"))
+	if err != nil {
+		return "", err
+	}
+	if len(reports) == 0 {
+		return "", fmt.Errorf("no run report in %s", filepath.Join(workingDirectory, ".dev", "reports"))
+	}
+	// The names are the start times of the runs, they sort in time order.
+	sort.Strings(reports)
+	return reports[len(reports)-1], nil
+}
+
+// WriteRunReport writes the report of the run, which ended with runErr.
+func WriteRunReport(ctx context.Context, runErr error) {
+	if session == nil {
+		return
+	}
+	path, err := NewRunReport(context.WithoutCancel(ctx), runErr).Write()
+	if err != nil {
+		fmt.Printf("Error writing run report: %s\n", err)
+		return
+	}
+	log.Printf("Run report written to %s", path)
+}
+
+func quote(text string) string {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return "(empty)"
+	}
+	return "> " + strings.ReplaceAll(text, "\n", "\n> ")
+}
+
+func cell(text string) string {
+	return strings.ReplaceAll(text, "|", `\|`)
+}
+
+func sortedKeys(m map[string]Usage) []string {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRunReport(t *testing.T) {
+	const model = "test-model"
+	cassette := &Cassette{Interactions: []Interaction{
+		toolCallInteraction(model, call("add_task", map[string]any{"title": "Create hello.txt", "notes": []string{"accept: test -s hello.txt"}})),
+		toolCallInteraction(model, call("add_task", map[string]any{"title": "Translate it"})),
+		textInteraction(model, "Planned."),
+		toolCallInteraction(model, call("write_file", map[string]any{"path": "hello.txt", "content": "hello\n"})),
+		toolCallInteraction(model, call("complete_task", map[string]any{"id": 1})),
+		textInteraction(model, "Created hello.txt."),
+		toolCallInteraction(model, call("skip_task", map[string]any{"id": 2, "reason": "not needed"})),
+		textInteraction(model, "Skipped."),
+		verdictInteraction(model, "yes"),
+	}}
+	setupRun(t, "Create hello.txt.\n", cassette)
+	ctx := context.Background()
+	if err := run(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	report := NewRunReport(ctx, nil)
+	if report.Input != "Create hello.txt.\n" || report.Outcome != "done" {
+		t.Errorf("Unexpected input or outcome: %q %q", report.Input, report.Outcome)
+	}
+	if len(report.Tasks) != 2 || report.Tasks[0].State != "done" || report.Tasks[1].State != "skipped" || report.Tasks[1].Reason != "not needed" {
+		t.Errorf("Unexpected tasks %+v", report.Tasks)
+	}
+	if len(report.Files) != 1 || report.Files[0] != (FileChange{Path: "hello.txt", Added: 1}) {
+		t.Errorf("Expected hello.txt to be listed as changed, got %+v", report.Files)
+	}
+	// write_file lints the file it writes; without a go.mod it stops after go vet.
+	var commands []string
+	for _, command := range report.Commands {
+		commands = append(commands, fmt.Sprintf("%s: %s %v %d", command.Source, command.Command, command.Passed, command.ExitCode))
+	}
+	want := []string{"lint_file: go mod tidy false 1", "lint_file: go vet . false 1", "accept: test -s hello.txt true 0"}
+	if strings.Join(commands, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expected the lint and acceptance commands to be listed, got %q", commands)
+	}
+	if !strings.Contains(report.Commands[1].Output, "go.mod file not found") {
+		t.Errorf("Expected the output of go vet to be kept, got %q", report.Commands[1].Output)
+	}
+	if len(report.Gates) == 0 || !report.Gates[0].Skipped {
+		t.Errorf("Expected the skipped gates, got %+v", report.Gates)
+	}
+	if len(report.Unresolved) != 0 || report.Usage.Calls != 9 {
+		t.Errorf("Unexpected unresolved items or usage: %q %+v", report.Unresolved, report.Usage)
+	}
+
+	path, err := report.Write()
+	if err != nil {
+		t.Fatal(err)
+	}
+	markdown, _ := os.ReadFile(path)
+	for _, want := range []string{"- Outcome: done\n", "> Create hello.txt.\n", "| #2 Translate it | skipped | 1 |  | not needed |", "hello.txt | 1 +", "| accept | `test -s hello.txt` | passed |", "## Unresolved\n\nNothing.\n"} {
+		if !strings.Contains(string(markdown), want) {
+			t.Errorf("Expected the report to contain %q:\n%s", want, markdown)
+		}
+	}
+	var twin RunReport
+	content, _ := os.ReadFile(strings.TrimSuffix(path, ".md") + ".json")
+	if err := json.Unmarshal(content, &twin); err != nil || len(twin.Tasks) != 2 || len(twin.Files) != 1 {
+		t.Errorf("Unexpected JSON report: %v\n%s", err, content)
+	}
+
+	stopped := NewRunReport(ctx, errors.New("budget exceeded"))
+	if stopped.Outcome != "stopped in the done phase: budget exceeded" {
+		t.Errorf("Unexpected outcome %q", stopped.Outcome)
+	}
+}
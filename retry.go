@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+type RetryConfig struct {
+	MaxAttempts  int `json:"max_attempts,omitempty"`
+	InitialDelay int `json:"initial_delay_seconds,omitempty"`
+	MaxDelay     int `json:"max_delay_seconds,omitempty"`
+}
+
+// ErrorKind classifies provider errors.
+type ErrorKind string
+
+const (
+	ErrorRateLimit     ErrorKind = "rate_limit"
+	ErrorServer        ErrorKind = "server"
+	ErrorTimeout       ErrorKind = "timeout"
+	ErrorNetwork       ErrorKind = "network"
+	ErrorContextLength ErrorKind = "context_length"
+	ErrorAuth          ErrorKind = "auth"
+	ErrorBadRequest    ErrorKind = "bad_request"
+	ErrorCanceled      ErrorKind = "canceled"
+	ErrorUnknown       ErrorKind = "unknown"
+)
+
+func (k ErrorKind) Retryable() bool {
+	switch k {
+	case ErrorRateLimit, ErrorServer, ErrorTimeout, ErrorNetwork:
+		return true
+	}
+	return false
+}
+
+// HTTPError is returned by providers when the API answers with an error status.
+type HTTPError struct {
+	StatusCode int
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("HTTP %d: %s", e.StatusCode, e.Err)
+}
+
+func (e *HTTPError) Unwrap() error {
+	return e.Err
+}
+
+// RetryError is returned when a retryable error persisted after every attempt.
+type RetryError struct {
+	Kind     ErrorKind
+	Attempts int
+	Err      error
+}
+
+func (e *RetryError) Error() string {
+	return fmt.Sprintf("giving up after %d attempts (%s): %s", e.Attempts, e.Kind, e.Err)
+}
+
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
+
+// FatalError is returned for errors that retrying cannot fix, like an invalid API key
+// or a prompt larger than the context window.
+type FatalError struct {
+	Kind ErrorKind
+	Err  error
+}
+
+func (e *FatalError) Error() string {
+	return fmt.Sprintf("%s error: %s", e.Kind, e.Err)
+}
+
+func (e *FatalError) Unwrap() error {
+	return e.Err
+}
+
+func ClassifyError(err error) ErrorKind {
+	if errors.Is(err, context.Canceled) {
+		return ErrorCanceled
+	}
+
+	statusCode := 0
+	var httpErr *HTTPError
+	var apiErr *openai.APIError
+	var requestErr *openai.RequestError
+	switch {
+	case errors.As(err, &httpErr):
+		statusCode = httpErr.StatusCode
+	case errors.As(err, &apiErr):
+		statusCode = apiErr.HTTPStatusCode
+	case errors.As(err, &requestErr):
+		statusCode = requestErr.HTTPStatusCode
+	}
+
+	message := strings.ToLower(err.Error())
+	if strings.Contains(message, "context length") || strings.Contains(message, "context window") ||
+		strings.Contains(message, "maximum context") || strings.Contains(message, "prompt is too long") ||
+		strings.Contains(message, "too many tokens") {
+		return ErrorContextLength
+	}
+
+	switch {
+	case statusCode == http.StatusTooManyRequests:
+		return ErrorRateLimit
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden || statusCode == http.StatusPaymentRequired:
+		return ErrorAuth
+	case statusCode == http.StatusRequestTimeout:
+		return ErrorTimeout
+	case statusCode >= 500:
+		return ErrorServer
+	case statusCode >= 400:
+		return ErrorBadRequest
+	}
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return ErrorTimeout
+	}
+	if errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) ||
+		strings.Contains(message, "connection reset") || strings.Contains(message, "connection refused") {
+		return ErrorNetwork
+	}
+	return ErrorUnknown
+}
+
+func retryAfter(err error) time.Duration {
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.RetryAfter
+	}
+	return 0
+}
+
+// parseRetryAfter reads a Retry-After header, in seconds or as an HTTP date.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(strings.TrimSpace(value)); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date)
+	}
+	return 0
+}
+
+// backoff returns the jittered exponential delay before the given retry attempt.
+func backoff(attempt int, initial time.Duration, max time.Duration) time.Duration {
+	delay := initial << attempt
+	if delay <= 0 || delay > max {
+		delay = max
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// createChatCompletion sends a request through the provider, retrying rate limits,
+// server errors and timeouts. It returns a *RetryError when the attempts run out,
+// a *FatalError for errors that are not worth retrying and a *BudgetError once the
+// run budget is spent. The usage of every successful call is recorded.
+func createChatCompletion(ctx context.Context, request openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	maxAttempts := config.Retry.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = 1
+	}
+	initial := time.Duration(config.Retry.InitialDelay) * time.Second
+	max := time.Duration(config.Retry.MaxDelay) * time.Second
+
+	for attempt := 0; ; attempt++ {
+		if err := usage.CheckBudget(config.Budget); err != nil {
+			return openai.ChatCompletionResponse{}, err
+		}
+		response, err := provider.CreateChatCompletion(ctx, request)
+		if err == nil {
+			usage.Record(request.Model, response.Usage)
+			return response, nil
+		}
+
+		kind := ClassifyError(err)
+		if !kind.Retryable() {
+			return response, &FatalError{Kind: kind, Err: err}
+		}
+		if attempt+1 >= maxAttempts {
+			return response, &RetryError{Kind: kind, Attempts: attempt + 1, Err: err}
+		}
+
+		delay := retryAfter(err)
+		if delay <= 0 {
+			delay = backoff(attempt, initial, max)
+		}
+		log.Printf("Retryable %s error (attempt %d/%d), retrying in %s: %s", kind, attempt+1, maxAttempts, delay.Round(time.Millisecond), err)
+		select {
+		case <-ctx.Done():
+			return response, &FatalError{Kind: ErrorCanceled, Err: ctx.Err()}
+		case <-time.After(delay):
+		}
+	}
+}
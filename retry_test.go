@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+type flakyProvider struct {
+	errs  []error
+	calls int
+}
+
+func (p *flakyProvider) CreateChatCompletion(ctx context.Context, request openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	p.calls++
+	if len(p.errs) > 0 {
+		err := p.errs[0]
+		p.errs = p.errs[1:]
+		return openai.ChatCompletionResponse{}, err
+	}
+	return openai.ChatCompletionResponse{Choices: []openai.ChatCompletionChoice{{Message: openai.ChatCompletionMessage{Content: "ok"}}}}, nil
+}
+
+func (p *flakyProvider) Capabilities() Capabilities {
+	return Capabilities{}
+}
+
+func TestCreateChatCompletionRetries(t *testing.T) {
+	config.Retry = RetryConfig{MaxAttempts: 3}
+	defer func() { provider = nil; config = Config{} }()
+
+	rateLimited := &HTTPError{StatusCode: 429, RetryAfter: time.Millisecond, Err: fmt.Errorf("slow down")}
+
+	t.Run("recovers after retryable errors", func(t *testing.T) {
+		p := &flakyProvider{errs: []error{rateLimited, &HTTPError{StatusCode: 503, Err: fmt.Errorf("unavailable")}}}
+		provider = p
+		response, err := createChatCompletion(context.Background(), openai.ChatCompletionRequest{})
+		if err != nil {
+			t.Fatalf("Expected success, got %v", err)
+		}
+		if p.calls != 3 || response.Choices[0].Message.Content != "ok" {
+			t.Errorf("Expected 3 calls and a response, got %d calls", p.calls)
+		}
+	})
+
+	t.Run("gives up with a RetryError", func(t *testing.T) {
+		provider = &flakyProvider{errs: []error{rateLimited, rateLimited, rateLimited}}
+		_, err := createChatCompletion(context.Background(), openai.ChatCompletionRequest{})
+		var retryErr *RetryError
+		if !errors.As(err, &retryErr) || retryErr.Attempts != 3 || retryErr.Kind != ErrorRateLimit {
+			t.Errorf("Expected RetryError after 3 attempts, got %v", err)
+		}
+	})
+
+	t.Run("does not retry fatal errors", func(t *testing.T) {
+		p := &flakyProvider{errs: []error{&HTTPError{StatusCode: 400, Err: fmt.Errorf("This model's maximum context length is 128000 tokens")}}}
+		provider = p
+		_, err := createChatCompletion(context.Background(), openai.ChatCompletionRequest{})
+		var fatalErr *FatalError
+		if !errors.As(err, &fatalErr) || fatalErr.Kind != ErrorContextLength || p.calls != 1 {
+			t.Errorf("Expected a context_length FatalError after 1 call, got %v after %d calls", err, p.calls)
+		}
+	})
+}
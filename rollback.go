@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"dev/tasks"
+)
+
+// The working tree is snapshotted when a task starts and restored when the task fails
+// or is blocked, so that its partial edits do not get in the way of the next tasks.
+// Snapshots are tree objects written with a temporary index: HEAD, the index and the
+// stash of the repository are left alone. Outside of a git repository they go to a
+// private repository under .dev. Ignored files are not part of the snapshots.
+
+// snapshotRepo returns the repository the snapshots are written to, with a temporary
+// index. The returned function removes the index.
+func snapshotRepo(ctx context.Context) (Repo, func(), error) {
+	repo := WorkingRepo()
+	if !repo.IsRepo(ctx) {
+		repo.GitDir = filepath.Join(workingDirectory, ".dev", "snapshots.git")
+		if _, err := os.Stat(repo.GitDir); os.IsNotExist(err) {
+			if _, err := WorkingRepo().git(ctx, "init", "-q", "--bare", repo.GitDir); err != nil {
+				return repo, nil, err
+			}
+		}
+	}
+	dir, err := os.MkdirTemp("", "dev-snapshot")
+	if err != nil {
+		return repo, nil, err
+	}
+	repo.Index = filepath.Join(dir, "index")
+	return repo, func() { os.RemoveAll(dir) }, nil
+}
+
+// snapshotPaths are the paths of the snapshots: everything but the files of the agent,
+// which keep track of the failure.
+func snapshotPaths() []string {
+	paths := append([]string{"."}, commitExclude()...)
+	for _, path := range []string{TasksPath(), Path("INPUT.md")} {
+		rel, _ := filepath.Rel(workingDirectory, path)
+		paths = append(paths, ":(exclude)"+rel)
+	}
+	return paths
+}
+
+// Snapshot writes the working tree to a tree object and returns it.
+func Snapshot(ctx context.Context) (string, error) {
+	repo, cleanup, err := snapshotRepo(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer cleanup()
+	return repo.WriteTree(ctx, snapshotPaths()...)
+}
+
+// DiffSinceStart runs git diff with args between the working tree when the run started
+// and now, so the changes the run already committed are included. paths are relative to
+// the working directory. It returns false when the run has no start snapshot.
+func DiffSinceStart(ctx context.Context, args []string, paths ...string) (string, bool, error) {
+	if session == nil || session.StartSnapshot == "" {
+		return "", false, nil
+	}
+	repo, cleanup, err := snapshotRepo(ctx)
+	if err != nil {
+		return "", true, err
+	}
+	defer cleanup()
+	current, err := repo.WriteTree(ctx, snapshotPaths()...)
+	if err != nil {
+		return "", true, err
+	}
+	args = append(append([]string{"diff", "--no-color", "--relative"}, args...), session.StartSnapshot, current)
+	if len(paths) > 0 {
+		args = append(append(args, "--"), paths...)
+	}
+	diff, err := repo.git(ctx, args...)
+	return diff, true, err
+}
+
+// Restore brings the working tree back to snapshot and writes the changes made since
+// to the patch file. It returns false when nothing changed.
+func Restore(ctx context.Context, snapshot, patch string) (bool, error) {
+	repo, cleanup, err := snapshotRepo(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer cleanup()
+	current, err := repo.WriteTree(ctx, snapshotPaths()...)
+	if err != nil {
+		return false, err
+	}
+	if current == snapshot {
+		return false, nil
+	}
+	diff, err := repo.git(ctx, "diff", "--binary", "--no-color", snapshot, current)
+	if err != nil {
+		return false, err
+	}
+	if err := os.MkdirAll(filepath.Dir(patch), 0755); err != nil {
+		return false, err
+	}
+	if err := os.WriteFile(patch, []byte(diff), 0644); err != nil {
+		return false, err
+	}
+	return true, repo.SwitchTree(ctx, current, snapshot)
+}
+
+func FailedPatchPath(id int) string {
+	return filepath.Join(workingDirectory, ".dev", "failed", strconv.Itoa(id)+".patch")
+}
+
+// snapshotTask snapshots the working tree for the task of the current iteration when it
+// starts. The snapshot is kept until the task is finished, so the next attempts of a task
+// roll back to where it first started. A task without a snapshot is not rolled back.
+func snapshotTask(ctx context.Context) {
+	if session.Snapshots[session.TaskID] != "" {
+		return
+	}
+	snapshot, err := Snapshot(ctx)
+	if err != nil {
+		log.Printf("Failed to snapshot the working tree, task #%d will not be rolled back: %v", session.TaskID, err)
+		return
+	}
+	if session.Snapshots == nil {
+		session.Snapshots = map[int]string{}
+	}
+	session.Snapshots[session.TaskID] = snapshot
+}
+
+// rollbackTask restores the snapshot of task id when the task failed or was blocked, and
+// drops it once the task is finished.
+func rollbackTask(ctx context.Context, id int) error {
+	snapshot := session.Snapshots[id]
+	if snapshot == "" {
+		return nil
+	}
+	doc, err := LoadTasks()
+	if err != nil {
+		return fmt.Errorf("reading TASKS.md: %w", err)
+	}
+	task := doc.Find(id)
+	if task == nil || task.State == tasks.Done || task.State == tasks.Skipped {
+		delete(session.Snapshots, id)
+		return nil
+	}
+	if task.State != tasks.Failed && task.State != tasks.Blocked {
+		return nil
+	}
+	patch := FailedPatchPath(task.ID)
+	changed, err := Restore(ctx, snapshot, patch)
+	if err != nil {
+		return fmt.Errorf("rolling back task #%d: %w", task.ID, err)
+	}
+	if changed {
+		log.Printf("Rolled back the changes of task #%d, see %s", task.ID, patch)
+	}
+	delete(session.Snapshots, id)
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	for _, git := range []bool{true, false} {
+		workingDirectory = t.TempDir()
+		ctx := context.Background()
+		var repo Repo
+		if git {
+			repo = initRepo(t)
+		}
+		write := func(name, content string) {
+			os.WriteFile(filepath.Join(workingDirectory, name), []byte(content), 0644)
+		}
+		write("README.md", "# test\n")
+		write("kept.txt", "kept\n")
+		write("TASKS.md", "- [ ] Task <!-- id:1 -->\n")
+
+		snapshot, err := Snapshot(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if changed, err := Restore(ctx, snapshot, FailedPatchPath(1)); changed || err != nil {
+			t.Errorf("Expected nothing to restore, got %t %v", changed, err)
+		}
+
+		write("README.md", "# broken\n")
+		write("new.txt", "new\n")
+		os.Remove(filepath.Join(workingDirectory, "kept.txt"))
+		write("TASKS.md", "- [!] Task <!-- id:1 -->\n")
+
+		if changed, err := Restore(ctx, snapshot, FailedPatchPath(1)); !changed || err != nil {
+			t.Fatalf("Restore failed: %t %v", changed, err)
+		}
+		for name, want := range map[string]string{"README.md": "# test\n", "kept.txt": "kept\n", "TASKS.md": "- [!] Task <!-- id:1 -->\n"} {
+			if content, _ := os.ReadFile(filepath.Join(workingDirectory, name)); string(content) != want {
+				t.Errorf("git %t: expected %s to be %q, got %q", git, name, want, content)
+			}
+		}
+		if _, err := os.Stat(filepath.Join(workingDirectory, "new.txt")); !os.IsNotExist(err) {
+			t.Errorf("git %t: expected new.txt to be removed, got %v", git, err)
+		}
+		patch, _ := os.ReadFile(FailedPatchPath(1))
+		for _, want := range []string{"+# broken", "+new", "-kept"} {
+			if !strings.Contains(string(patch), want) {
+				t.Errorf("git %t: expected the patch to contain %q:\n%s", git, want, patch)
+			}
+		}
+		if git {
+			if status, _ := repo.git(ctx, "status", "--porcelain"); status != "?? .dev/\n?? TASKS.md\n?? kept.txt\n" {
+				t.Errorf("Expected the index to be left alone, got status %q", status)
+			}
+		}
+	}
+}
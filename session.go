@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// Phases of a run, in the order the main loop goes through them.
+const (
+	PhaseClarify = "clarify" // asking about the ambiguities of INPUT.md, when enabled
+	PhasePlan    = "plan"    // turning INPUT.md into TASKS.md
+	PhaseTask    = "task"    // the executor works on the next task
+	PhaseReview  = "review"  // the judge checks the executor response
+	PhaseDone    = "done"
+
+	PhaseWiki = "wiki" // `dev wiki`, outside of a run
+)
+
+// Session is the state of a run, saved after every model turn and tool result
+// so that `dev resume` can continue an interrupted run.
+type Session struct {
+	Phase     string `json:"phase"`
+	Iteration int    `json:"iteration"`
+	// Tasks is the TASKS.md content the current iteration started from.
+	Tasks string `json:"tasks,omitempty"`
+	// TaskID is the task of the current iteration.
+	TaskID int `json:"task_id,omitempty"`
+	// Input is INPUT.md when the run started, and StartSnapshot the working tree.
+	Input         string `json:"input,omitempty"`
+	StartSnapshot string `json:"start_snapshot,omitempty"`
+	// Commands and Gates are the commands run and the last gate results, for the report.
+	Commands []CommandRecord `json:"commands,omitempty"`
+	Gates    []GateResult    `json:"gates,omitempty"`
+
+	// Questions are the questions asked before planning and their answers.
+	Questions []Question `json:"questions,omitempty"`
+	// Plan is the plan submitted by the planner, also saved to .dev/plan.json.
+	Plan *Plan `json:"plan,omitempty"`
+	// OnlyTask limits the run to one task, for the parallel workers.
+	OnlyTask int `json:"only_task,omitempty"`
+	// OnlyPhase limits the run to one phase, for `dev run -phase`.
+	OnlyPhase string `json:"only_phase,omitempty"`
+	// Ephemeral sessions, like the one of `dev wiki`, are never saved so they do not
+	// replace the session of a run.
+	Ephemeral bool `json:"-"`
+	// Snapshots are the working trees when the unfinished tasks first started, by task
+	// id, restored if they fail.
+	Snapshots map[int]string `json:"snapshots,omitempty"`
+	// Response is the last executor response, judged in the review phase.
+	Response string `json:"response,omitempty"`
+	// Verdict is the last review, its unmet items are given to the next task.
+	Verdict *Verdict `json:"verdict,omitempty"`
+
+	Messages []openai.ChatCompletionMessage `json:"messages"`
+	Config   Config                         `json:"config"`
+	Usage    *UsageTracker                  `json:"usage"`
+	Loop     *LoopDetector                  `json:"loop"`
+
+	StartedAt time.Time `json:"started_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+var session *Session
+
+func SessionPath() string {
+	return filepath.Join(workingDirectory, ".dev", "session.json")
+}
+
+func NewSession() *Session {
+	return &Session{
+		Phase:     firstPhase(),
+		Config:    config,
+		StartedAt: time.Now(),
+	}
+}
+
+func firstPhase() string {
+	if config.Clarify {
+		return PhaseClarify
+	}
+	return PhasePlan
+}
+
+func LoadSession() (*Session, error) {
+	return loadSession(SessionPath())
+}
+
+func loadSession(path string) (*Session, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no session to resume in %s", filepath.Dir(filepath.Dir(path)))
+		}
+		return nil, err
+	}
+	var s Session
+	if err := json.Unmarshal(content, &s); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return &s, nil
+}
+
+// SetPhase moves the run to the next phase with a fresh conversation.
+func (s *Session) SetPhase(phase string) {
+	s.Phase = phase
+	messages = nil
+	loop.ResetConversation()
+}
+
+// Save writes the session, including the current conversation, to .dev/session.json.
+// The file is replaced atomically so a crash never leaves a truncated session behind.
+func (s *Session) Save() error {
+	if s.Ephemeral {
+		return nil
+	}
+	s.Messages = messages
+	s.Usage = usage
+	s.Loop = loop
+	s.UpdatedAt = time.Now()
+
+	path := SessionPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	// Tool calls still running can record commands meanwhile.
+	commandsMu.Lock()
+	data, err := json.MarshalIndent(s, "", "  ")
+	commandsMu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// saveSession saves the global session, if any. Errors are logged, a failed save
+// must not stop the run.
+func saveSession() {
+	if session == nil {
+		return
+	}
+	if err := session.Save(); err != nil {
+		log.Printf("Error saving session: %s", err)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"dev/tasks"
+)
+
+// tasksMu serializes the task tools, which load, edit and save TASKS.md.
+var tasksMu sync.Mutex
+
+func TasksPath() string {
+	return filepath.Join(workingDirectory, "TASKS.md")
+}
+
+func LoadTasks() (*tasks.Document, error) {
+	return tasks.Load(TasksPath())
+}
+
+// updateTasks loads TASKS.md, applies update and saves it when update succeeds.
+func updateTasks(update func(doc *tasks.Document) (string, error)) string {
+	tasksMu.Lock()
+	defer tasksMu.Unlock()
+
+	doc, err := LoadTasks()
+	if err != nil {
+		return fmt.Sprintf("Error reading TASKS.md: %s", err)
+	}
+	result, err := update(doc)
+	if err != nil {
+		return fmt.Sprintf("Error: %s", err)
+	}
+	if err := doc.Save(TasksPath()); err != nil {
+		return fmt.Sprintf("Error writing TASKS.md: %s", err)
+	}
+	return result
+}
+
+func AddTask(title string, parentID int, notes []string) string {
+	return updateTasks(func(doc *tasks.Document) (string, error) {
+		task, err := doc.Add(title, parentID, notes...)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Added task #%d: %s", task.ID, task.Title), nil
+	})
+}
+
+func StartTask(id int) string {
+	return updateTasks(func(doc *tasks.Document) (string, error) {
+		if err := doc.SetState(id, tasks.InProgress); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Started task #%d", id), nil
+	})
+}
+
+// CompleteTask marks the task as done once its acceptance checks pass. When they fail
+// the task stays open and the failures are returned to the model.
+func CompleteTask(ctx context.Context, id int) string {
+	tasksMu.Lock()
+	doc, err := LoadTasks()
+	tasksMu.Unlock()
+	if err != nil {
+		return fmt.Sprintf("Error reading TASKS.md: %s", err)
+	}
+	task := doc.Find(id)
+	if task == nil {
+		return fmt.Sprintf("Error: no task with id %d", id)
+	}
+	checks, err := TaskChecks(task)
+	if err != nil {
+		return fmt.Sprintf("Error: invalid acceptance check of task #%d: %s", id, err)
+	}
+	if failures := RunChecks(ctx, checks); failures != "" {
+		log.Printf("Acceptance checks of task #%d failed:\n%s", id, failures)
+		updateTasks(func(doc *tasks.Document) (string, error) {
+			doc.Meta(id).LastError = "acceptance checks failed\n" + failures
+			return "", nil
+		})
+		return fmt.Sprintf("The acceptance checks of task #%d failed, it is not done:\n%s\nFix the problems and call complete_task again.", id, failures)
+	}
+
+	return updateTasks(func(doc *tasks.Document) (string, error) {
+		task := doc.Find(id)
+		if task == nil {
+			return "", fmt.Errorf("no task with id %d", id)
+		}
+		for _, child := range task.Children {
+			if child.State.Open() {
+				return "", fmt.Errorf("task #%d has open subtasks, complete or fail #%d first", id, child.ID)
+			}
+		}
+		if err := doc.SetState(id, tasks.Done); err != nil {
+			return "", err
+		}
+		log.Printf("Completed task #%d: %s", id, task.Title)
+		return fmt.Sprintf("Completed task #%d", id), nil
+	})
+}
+
+func FailTask(id int, reason string) string {
+	return updateTasks(func(doc *tasks.Document) (string, error) {
+		if err := doc.Fail(id, reason); err != nil {
+			return "", err
+		}
+		log.Printf("Failed task #%d: %s", id, reason)
+		return fmt.Sprintf("Failed task #%d", id), nil
+	})
+}
+
+func SkipTask(id int, reason string) string {
+	return updateTasks(func(doc *tasks.Document) (string, error) {
+		if err := doc.Skip(id, reason); err != nil {
+			return "", err
+		}
+		log.Printf("Skipped task #%d: %s", id, reason)
+		return fmt.Sprintf("Skipped task #%d", id), nil
+	})
+}
+
+func ListTasks() string {
+	tasksMu.Lock()
+	defer tasksMu.Unlock()
+
+	doc, err := LoadTasks()
+	if err != nil {
+		return fmt.Sprintf("Error reading TASKS.md: %s", err)
+	}
+	if len(doc.Tasks) == 0 {
+		return "No tasks"
+	}
+	return doc.Format()
+}
+
+// taskPrompt asks the executor to work on task, with the rest of the list as context.
+func taskPrompt(doc *tasks.Document, task *tasks.Task, note string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Your task is #%d: %s\n", task.ID, task.Title)
+	for parent := task.Parent; parent != nil; parent = parent.Parent {
+		fmt.Fprintf(&b, "It is part of #%d: %s\n", parent.ID, parent.Title)
+	}
+	for _, n := range task.Notes {
+		fmt.Fprintf(&b, "  %s\n", n)
+	}
+	if meta := doc.Meta(task.ID); meta.Attempts > 1 {
+		fmt.Fprintf(&b, "This is attempt %d of the task.", meta.Attempts)
+		if meta.LastError != "" {
+			fmt.Fprintf(&b, " The last attempt failed: %s", meta.LastError)
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString(`
+Work only on this task. When it is done, call complete_task with its id. If it cannot be done,
+call fail_task with the reason, or skip_task when it is not needed anymore.
+Add the follow-up work you find with add_task.
+Do not edit TASKS.md with write_file.
+`)
+	if note != "" {
+		b.WriteString("\n" + note + "\n")
+	}
+	b.WriteString("\nTASKS:\n" + doc.Format())
+	return b.String()
+}
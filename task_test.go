@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestTaskTools(t *testing.T) {
+	workingDirectory = t.TempDir()
+	session = NewSession()
+	defer func() { session = nil }()
+
+	tool := func(name string, arguments map[string]any) string {
+		return ToolCall(context.Background(), openai.ToolCall{Function: call(name, arguments)})
+	}
+	if got := tool("list_tasks", nil); got != "No tasks" {
+		t.Errorf("Expected no tasks, got %q", got)
+	}
+	tool("add_task", map[string]any{"title": "Server"})
+	tool("add_task", map[string]any{"title": "Routes", "parent_id": 1, "notes": []string{"GET /"}})
+	tool("add_task", map[string]any{"title": "Docs"})
+
+	if got := tool("complete_task", map[string]any{"id": 1}); !strings.Contains(got, "open subtasks") {
+		t.Errorf("Expected a parent with open subtasks not to complete, got %q", got)
+	}
+	tool("start_task", map[string]any{"id": 2})
+	tool("complete_task", map[string]any{"id": 2})
+	tool("fail_task", map[string]any{"id": 3, "reason": "no docs tool"})
+	if got := tool("complete_task", map[string]any{"id": 9}); !strings.HasPrefix(got, "Error") {
+		t.Errorf("Expected an error for an unknown task, got %q", got)
+	}
+
+	content, _ := os.ReadFile(TasksPath())
+	want := `- [ ] Server <!-- id:1 -->
+  - [x] Routes <!-- id:2 -->
+    GET /
+- [!] Docs <!-- id:3 -->
+  failed: no docs tool
+`
+	if string(content) != want {
+		t.Errorf("Unexpected TASKS.md:\n%s", content)
+	}
+
+	session.TaskID = 1
+	if err := blockCurrentTask("stalled"); err != nil {
+		t.Fatal(err)
+	}
+	doc, _ := LoadTasks()
+	if !strings.Contains(doc.String(), "- [b] Server <!-- id:1 -->\n  blocked: stalled\n") {
+		t.Errorf("Expected task 1 to be blocked, got:\n%s", doc)
+	}
+	// The failed task is retried.
+	if next := doc.Next(); next == nil || next.ID != 3 {
+		t.Errorf("Expected task 3 to be next, got %+v", next)
+	}
+}
@@ -0,0 +1,67 @@
+package tasks
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Dependencies are task notes too:
+//
+//	depends: 1, 4    the task can only start once tasks #1 and #4 are done or skipped
+//	independent      the task can run at the same time as any other ready task
+const (
+	DependsPrefix   = "depends:"
+	IndependentNote = "independent"
+)
+
+// Depends returns the ids of the tasks t depends on.
+func (t *Task) Depends() []int {
+	var ids []int
+	for _, note := range t.Notes {
+		if !strings.HasPrefix(note, DependsPrefix) {
+			continue
+		}
+		for _, field := range strings.FieldsFunc(strings.TrimPrefix(note, DependsPrefix), func(r rune) bool {
+			return r == ',' || r == ' ' || r == '#'
+		}) {
+			if id, err := strconv.Atoi(field); err == nil {
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids
+}
+
+// Independent reports whether t was marked independent or is part of the dependency
+// graph, which makes it safe to run at the same time as the other ready tasks.
+func (t *Task) Independent() bool {
+	for _, note := range t.Notes {
+		if note == IndependentNote || strings.HasPrefix(note, DependsPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Ready reports whether the dependencies of t are done or skipped. Unknown ids are
+// ignored.
+func (d *Document) Ready(t *Task) bool {
+	for _, id := range t.Depends() {
+		if dep := d.Find(id); dep != nil && dep.State != Done && dep.State != Skipped {
+			return false
+		}
+	}
+	return true
+}
+
+// Parallel returns the pending or failed tasks that are independent and ready, which
+// can be worked on at the same time.
+func (d *Document) Parallel() []*Task {
+	var ready []*Task
+	for _, task := range d.All() {
+		if (task.State == Pending || task.State == Failed) && !task.hasOpenChildren() && task.Independent() && d.Ready(task) {
+			ready = append(ready, task)
+		}
+	}
+	return ready
+}
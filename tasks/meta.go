@@ -0,0 +1,72 @@
+package tasks
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Meta is what the checklist does not show about a task.
+type Meta struct {
+	// Attempts counts the times the task was started.
+	Attempts   int       `json:"attempts"`
+	StartedAt  time.Time `json:"started_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	// Reason is why the task was blocked or skipped.
+	Reason    string `json:"reason,omitempty"`
+	LastError string `json:"last_error,omitempty"`
+	// Commit is the commit of the task once it is done.
+	Commit string `json:"commit,omitempty"`
+}
+
+var now = time.Now
+
+// MetaPath returns the metadata file of the checklist at path.
+func MetaPath(path string) string {
+	return strings.TrimSuffix(path, ".md") + ".meta.json"
+}
+
+// Meta returns the metadata of the task with id, creating it when needed.
+func (d *Document) Meta(id int) *Meta {
+	meta := d.meta[id]
+	if meta == nil {
+		meta = &Meta{}
+		d.meta[id] = meta
+	}
+	return meta
+}
+
+func (d *Document) loadMeta(path string) error {
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(content, &d.meta); err != nil {
+		return fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if d.meta == nil {
+		d.meta = map[int]*Meta{}
+	}
+	return nil
+}
+
+// saveMeta writes the metadata of the tasks still in the checklist.
+func (d *Document) saveMeta(path string) error {
+	meta := map[int]*Meta{}
+	for _, task := range d.All() {
+		if m := d.meta[task.ID]; m != nil {
+			meta[task.ID] = m
+		}
+	}
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(path, data)
+}
@@ -0,0 +1,478 @@
+// Package tasks parses the TASKS.md checklist into a tree of tasks and writes it
+// back without touching the lines it did not change.
+//
+// A task is a markdown list item with a checkbox:
+//
+//   - [ ] pending
+//   - [~] in progress
+//   - [x] done
+//   - [!] failed, retried until it is blocked
+//   - [b] blocked, given up on
+//   - [-] skipped, not needed anymore
+//
+// Every task gets an id, kept in a trailing <!-- id:N --> comment. Items indented
+// under a task are its subtasks, other indented lines are its notes. Attempts and
+// timestamps are kept next to the checklist, in TASKS.meta.json for TASKS.md.
+package tasks
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type State string
+
+const (
+	Pending    State = " "
+	InProgress State = "~"
+	Done       State = "x"
+	Failed     State = "!"
+	Blocked    State = "b"
+	Skipped    State = "-"
+)
+
+var states = map[string]State{" ": Pending, "~": InProgress, "x": Done, "X": Done, "!": Failed, "b": Blocked, "-": Skipped}
+
+func (s State) String() string {
+	switch s {
+	case Pending:
+		return "pending"
+	case InProgress:
+		return "in progress"
+	case Done:
+		return "done"
+	case Failed:
+		return "failed"
+	case Blocked:
+		return "blocked"
+	case Skipped:
+		return "skipped"
+	}
+	return string(s)
+}
+
+// Open reports whether the task still has to be worked on. Failed tasks are retried.
+func (s State) Open() bool {
+	return s == Pending || s == InProgress || s == Failed
+}
+
+type Task struct {
+	ID       int
+	State    State
+	Title    string
+	Notes    []string
+	Children []*Task
+	Parent   *Task
+
+	line   *line
+	indent int
+	// prefix is the indentation and bullet before the checkbox, "  - " for example.
+	prefix string
+}
+
+// line is one line of the file. Task lines are rendered from their task when it changed.
+type line struct {
+	text  string
+	task  *Task
+	dirty bool
+}
+
+// Document is a parsed TASKS.md.
+type Document struct {
+	Tasks  []*Task
+	lines  []*line
+	nextID int
+	meta   map[int]*Meta
+	// noFinalNewline keeps a file that did not end with a newline that way.
+	noFinalNewline bool
+}
+
+var (
+	taskLine  = regexp.MustCompile(`^(\s*[-*+] )\[(.)\] (.*)$`)
+	idComment = regexp.MustCompile(`\s*<!-- id:(\d+) -->\s*$`)
+)
+
+// Parse reads a checklist. Tasks without an id get a new one, written on the next save.
+func Parse(content string) *Document {
+	d := &Document{nextID: 1, meta: map[int]*Meta{}}
+	if content == "" {
+		return d
+	}
+	d.noFinalNewline = !strings.HasSuffix(content, "\n")
+	text := strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+
+	// stack holds the tasks the next lines can belong to, innermost last.
+	var stack []*Task
+	var unnumbered []*Task
+	for _, raw := range text {
+		l := &line{text: raw}
+		d.lines = append(d.lines, l)
+		indent := len(raw) - len(strings.TrimLeft(raw, " \t"))
+
+		match := taskLine.FindStringSubmatch(raw)
+		state, known := Pending, false
+		if match != nil {
+			state, known = states[match[2]]
+		}
+		if !known {
+			if strings.TrimSpace(raw) == "" {
+				continue
+			}
+			for len(stack) > 0 && stack[len(stack)-1].indent >= indent {
+				stack = stack[:len(stack)-1]
+			}
+			if len(stack) > 0 {
+				task := stack[len(stack)-1]
+				task.Notes = append(task.Notes, strings.TrimSpace(raw))
+			}
+			continue
+		}
+
+		task := &Task{State: state, Title: match[3], line: l, indent: indent, prefix: match[1]}
+		if id := idComment.FindStringSubmatch(task.Title); id != nil {
+			task.ID, _ = strconv.Atoi(id[1])
+			task.Title = strings.TrimSpace(idComment.ReplaceAllString(task.Title, ""))
+		}
+		l.task = task
+		if task.ID == 0 || d.Find(task.ID) != nil {
+			task.ID = 0
+			l.dirty = true
+			unnumbered = append(unnumbered, task)
+		}
+		if task.ID >= d.nextID {
+			d.nextID = task.ID + 1
+		}
+
+		for len(stack) > 0 && stack[len(stack)-1].indent >= indent {
+			stack = stack[:len(stack)-1]
+		}
+		if len(stack) > 0 {
+			task.Parent = stack[len(stack)-1]
+			task.Parent.Children = append(task.Parent.Children, task)
+		} else {
+			d.Tasks = append(d.Tasks, task)
+		}
+		stack = append(stack, task)
+	}
+	for _, task := range unnumbered {
+		task.ID = d.nextID
+		d.nextID++
+	}
+	return d
+}
+
+// Load reads the checklist at path and its metadata.
+func Load(path string) (*Document, error) {
+	content, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	d := Parse(string(content))
+	if err := d.loadMeta(MetaPath(path)); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Save writes the checklist and its metadata atomically.
+func (d *Document) Save(path string) error {
+	if err := writeFile(path, []byte(d.String())); err != nil {
+		return err
+	}
+	return d.saveMeta(MetaPath(path))
+}
+
+func writeFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (d *Document) String() string {
+	if len(d.lines) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	for i, l := range d.lines {
+		if l.task != nil && l.dirty {
+			l.text = l.task.render()
+			l.dirty = false
+		}
+		b.WriteString(l.text)
+		if i < len(d.lines)-1 || !d.noFinalNewline {
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
+
+func (t *Task) render() string {
+	return fmt.Sprintf("%s[%s] %s <!-- id:%d -->", t.prefix, string(t.State), t.Title, t.ID)
+}
+
+// All returns every task, parents before their subtasks.
+func (d *Document) All() []*Task {
+	var all []*Task
+	var walk func([]*Task)
+	walk = func(tasks []*Task) {
+		for _, task := range tasks {
+			all = append(all, task)
+			walk(task.Children)
+		}
+	}
+	walk(d.Tasks)
+	return all
+}
+
+func (d *Document) Find(id int) *Task {
+	for _, task := range d.All() {
+		if task.ID == id {
+			return task
+		}
+	}
+	return nil
+}
+
+// Next returns the task to work on: the task in progress, or else the first pending
+// task, or else the first failed one, skipping the tasks with open subtasks or
+// dependencies. It returns nil when no task is ready.
+func (d *Document) Next() *Task {
+	all := d.All()
+	for _, state := range []State{InProgress, Pending, Failed} {
+		for _, task := range all {
+			if task.State == state && !task.hasOpenChildren() && d.Ready(task) {
+				return task
+			}
+		}
+	}
+	return nil
+}
+
+func (t *Task) hasOpenChildren() bool {
+	for _, child := range t.Children {
+		if child.State.Open() || child.hasOpenChildren() {
+			return true
+		}
+	}
+	return false
+}
+
+// Open returns the number of tasks still to be worked on.
+func (d *Document) Open() int {
+	open := 0
+	for _, task := range d.All() {
+		if task.State.Open() {
+			open++
+		}
+	}
+	return open
+}
+
+// Add appends a task, at the top level when parentID is 0 or as the last subtask of
+// the parent. Notes are written indented under it.
+func (d *Document) Add(title string, parentID int, notes ...string) (*Task, error) {
+	title = strings.TrimSpace(strings.ReplaceAll(title, "\n", " "))
+	if title == "" {
+		return nil, fmt.Errorf("empty task title")
+	}
+	task := &Task{ID: d.nextID, State: Pending, Title: title, prefix: "- "}
+	at := len(d.lines)
+	if parentID != 0 {
+		parent := d.Find(parentID)
+		if parent == nil {
+			return nil, fmt.Errorf("no task with id %d", parentID)
+		}
+		task.Parent = parent
+		task.indent = parent.indent + 2
+		task.prefix = strings.Repeat(" ", task.indent) + strings.TrimLeft(parent.prefix, " \t")
+		if len(parent.Children) > 0 {
+			// Follow the indentation and bullet of the other subtasks.
+			sibling := parent.Children[len(parent.Children)-1]
+			task.indent, task.prefix = sibling.indent, sibling.prefix
+		}
+		at = d.end(parent)
+		parent.Children = append(parent.Children, task)
+	} else {
+		d.Tasks = append(d.Tasks, task)
+	}
+	d.nextID++
+
+	task.line = &line{task: task, dirty: true}
+	inserted := []*line{task.line}
+	for _, note := range notes {
+		task.Notes = append(task.Notes, note)
+		inserted = append(inserted, &line{text: strings.Repeat(" ", task.indent+2) + note})
+	}
+	d.lines = append(d.lines[:at], append(inserted, d.lines[at:]...)...)
+	return task, nil
+}
+
+// AddNote writes a note under the task, after its other notes.
+func (d *Document) AddNote(id int, note string) error {
+	task := d.Find(id)
+	if task == nil {
+		return fmt.Errorf("no task with id %d", id)
+	}
+	at := d.index(task.line) + 1
+	for at < len(d.lines) && d.lines[at].task == nil && d.indentOf(at) > task.indent {
+		at++
+	}
+	task.Notes = append(task.Notes, note)
+	l := &line{text: strings.Repeat(" ", task.indent+2) + note}
+	d.lines = append(d.lines[:at], append([]*line{l}, d.lines[at:]...)...)
+	return nil
+}
+
+// setNote replaces the note of the task starting with prefix, or adds it.
+func (d *Document) setNote(task *Task, prefix, text string) error {
+	note := prefix + text
+	for at := d.index(task.line) + 1; at < len(d.lines) && d.lines[at].task == nil && d.indentOf(at) > task.indent; at++ {
+		if trimmed := strings.TrimSpace(d.lines[at].text); strings.HasPrefix(trimmed, prefix) {
+			d.lines[at].text = strings.Repeat(" ", d.indentOf(at)) + note
+			for i, n := range task.Notes {
+				if n == trimmed {
+					task.Notes[i] = note
+				}
+			}
+			return nil
+		}
+	}
+	return d.AddNote(task.ID, note)
+}
+
+// SetState changes the state of a task and records when it happened.
+func (d *Document) SetState(id int, state State) error {
+	task := d.Find(id)
+	if task == nil {
+		return fmt.Errorf("no task with id %d", id)
+	}
+	if task.State != state {
+		task.State = state
+		task.line.dirty = true
+	}
+	meta := d.Meta(id)
+	meta.UpdatedAt = now()
+	switch state {
+	case Done, Blocked, Skipped:
+		meta.FinishedAt = meta.UpdatedAt
+	default:
+		meta.FinishedAt = time.Time{}
+	}
+	return nil
+}
+
+// Start marks the task as in progress and counts one more attempt.
+func (d *Document) Start(id int) error {
+	if err := d.SetState(id, InProgress); err != nil {
+		return err
+	}
+	meta := d.Meta(id)
+	meta.Attempts++
+	if meta.StartedAt.IsZero() {
+		meta.StartedAt = meta.UpdatedAt
+	}
+	return nil
+}
+
+// Fail marks the task as failed with the error of its last attempt.
+func (d *Document) Fail(id int, lastError string) error {
+	if err := d.SetState(id, Failed); err != nil {
+		return err
+	}
+	d.Meta(id).LastError = lastError
+	return d.setNote(d.Find(id), "failed: ", lastError)
+}
+
+// Block gives up on the task.
+func (d *Document) Block(id int, reason string) error {
+	if err := d.SetState(id, Blocked); err != nil {
+		return err
+	}
+	d.Meta(id).Reason = reason
+	return d.setNote(d.Find(id), "blocked: ", reason)
+}
+
+// Skip marks the task as not needed anymore.
+func (d *Document) Skip(id int, reason string) error {
+	if err := d.SetState(id, Skipped); err != nil {
+		return err
+	}
+	d.Meta(id).Reason = reason
+	return d.setNote(d.Find(id), "skipped: ", reason)
+}
+
+// end returns the index after the last line of the task and its subtasks and notes.
+func (d *Document) end(task *Task) int {
+	at := d.index(task.line) + 1
+	for at < len(d.lines) {
+		if strings.TrimSpace(d.lines[at].text) != "" || d.lines[at].task != nil {
+			if d.indentOf(at) <= task.indent {
+				break
+			}
+		}
+		at++
+	}
+	// Blank lines after the task belong to what follows.
+	for at > 0 && d.lines[at-1].task == nil && strings.TrimSpace(d.lines[at-1].text) == "" {
+		at--
+	}
+	return at
+}
+
+func (d *Document) index(l *line) int {
+	for i, other := range d.lines {
+		if other == l {
+			return i
+		}
+	}
+	return -1
+}
+
+func (d *Document) indentOf(i int) int {
+	if task := d.lines[i].task; task != nil {
+		return task.indent
+	}
+	text := d.lines[i].text
+	return len(text) - len(strings.TrimLeft(text, " \t"))
+}
+
+// Format lists the tasks as an indented checklist with their ids, states and notes.
+func (d *Document) Format() string {
+	var b strings.Builder
+	for _, task := range d.All() {
+		depth := 0
+		for parent := task.Parent; parent != nil; parent = parent.Parent {
+			depth++
+		}
+		indent := strings.Repeat("  ", depth)
+		state := task.State.String()
+		if meta := d.meta[task.ID]; meta != nil && meta.Attempts > 0 {
+			state += fmt.Sprintf(", %d attempts", meta.Attempts)
+		}
+		fmt.Fprintf(&b, "%s- #%d [%s] %s\n", indent, task.ID, state, task.Title)
+		for _, note := range task.Notes {
+			fmt.Fprintf(&b, "%s    %s\n", indent, note)
+		}
+	}
+	return b.String()
+}
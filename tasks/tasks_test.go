@@ -0,0 +1,226 @@
+package tasks
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const checklist = `# Tasks
+
+- [x] Set up the module <!-- id:1 -->
+- [ ] Add the server <!-- id:2 -->
+  Listen on :8080.
+  * [~] Routes <!-- id:3 -->
+  * [ ] Handlers <!-- id:4 -->
+    - [!] Error pages <!-- id:5 -->
+      Needs a design.
+
+- [ ] Write the README <!-- id:6 -->
+`
+
+func TestParseRoundTrip(t *testing.T) {
+	d := Parse(checklist)
+	if got := d.String(); got != checklist {
+		t.Errorf("Expected an unchanged document to round-trip, got:\n%s", got)
+	}
+	if len(d.Tasks) != 3 || len(d.All()) != 6 {
+		t.Fatalf("Expected 3 top-level tasks and 6 in total, got %d and %d", len(d.Tasks), len(d.All()))
+	}
+	server := d.Find(2)
+	if len(server.Children) != 2 || server.Children[1].Children[0].ID != 5 {
+		t.Errorf("Unexpected tree under task 2: %+v", server.Children)
+	}
+	if strings.Join(server.Notes, "|") != "Listen on :8080." || strings.Join(d.Find(5).Notes, "|") != "Needs a design." {
+		t.Errorf("Unexpected notes: %q %q", server.Notes, d.Find(5).Notes)
+	}
+	if d.Find(3).State != InProgress || d.Find(5).State != Failed || d.Find(1).State != Done {
+		t.Errorf("Unexpected states")
+	}
+
+	noNewline := strings.TrimSuffix(checklist, "\n")
+	if got := Parse(noNewline).String(); got != noNewline {
+		t.Errorf("Expected a missing final newline to be kept, got %q", got[len(got)-10:])
+	}
+}
+
+func TestParseAssignsIDs(t *testing.T) {
+	d := Parse("- [ ] First\n- [X] Second <!-- id:4 -->\n- [ ] Third <!-- id:4 -->\n")
+	want := "- [ ] First <!-- id:5 -->\n- [X] Second <!-- id:4 -->\n- [ ] Third <!-- id:6 -->\n"
+	if got := d.String(); got != want {
+		t.Errorf("Expected new ids for missing and duplicate ones, got:\n%s", got)
+	}
+}
+
+func TestEdit(t *testing.T) {
+	d := Parse(checklist)
+
+	if next := d.Next(); next == nil || next.ID != 3 {
+		t.Fatalf("Expected the task in progress to be next, got %+v", next)
+	}
+	if err := d.SetState(3, Done); err != nil {
+		t.Fatal(err)
+	}
+	// Task 4 waits for its failed subtask, pending tasks come first.
+	if next := d.Next(); next == nil || next.ID != 6 {
+		t.Fatalf("Expected task 6 to be next, got %+v", next)
+	}
+
+	task, err := d.Add("Tests", 2, "accept: go test ./...")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.ID != 7 || task.Parent.ID != 2 {
+		t.Errorf("Unexpected new task: %+v", task)
+	}
+	if _, err := d.Add("Release", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Fail(4, "no route"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Fail(4, "no router"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Add("Orphan", 42); err == nil {
+		t.Errorf("Expected an error for an unknown parent")
+	}
+
+	want := `# Tasks
+
+- [x] Set up the module <!-- id:1 -->
+- [ ] Add the server <!-- id:2 -->
+  Listen on :8080.
+  * [x] Routes <!-- id:3 -->
+  * [!] Handlers <!-- id:4 -->
+    failed: no router
+    - [!] Error pages <!-- id:5 -->
+      Needs a design.
+  * [ ] Tests <!-- id:7 -->
+    accept: go test ./...
+
+- [ ] Write the README <!-- id:6 -->
+- [ ] Release <!-- id:8 -->
+`
+	if got := d.String(); got != want {
+		t.Errorf("Unexpected document:\n%s", got)
+	}
+	if reparsed := Parse(want); reparsed.String() != want || len(reparsed.All()) != 8 {
+		t.Errorf("Expected the edited document to parse back")
+	}
+	if next := d.Next(); next == nil || next.ID != 7 {
+		t.Errorf("Expected task 7 to be next, got %+v", next)
+	}
+	if d.Open() != 6 {
+		t.Errorf("Expected 6 open tasks, got %d", d.Open())
+	}
+}
+
+func TestStatesAndMeta(t *testing.T) {
+	clock := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	now = func() time.Time { clock = clock.Add(time.Minute); return clock }
+	defer func() { now = time.Now }()
+
+	path := filepath.Join(t.TempDir(), "TASKS.md")
+	d := Parse("- [ ] One\n- [ ] Two\n- [ ] Three\n")
+	if err := d.Start(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Fail(1, "tests fail"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Skip(3, "not needed"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Pending tasks go before failed ones, which are retried.
+	if next := d.Next(); next == nil || next.ID != 2 {
+		t.Fatalf("Expected task 2 to be next, got %+v", next)
+	}
+	d.Start(2)
+	d.SetState(2, Done)
+	if next := d.Next(); next == nil || next.ID != 1 {
+		t.Fatalf("Expected the failed task to be retried, got %+v", next)
+	}
+	d.Start(1)
+	if err := d.Block(1, "gave up after 2 attempts"); err != nil {
+		t.Fatal(err)
+	}
+	if d.Next() != nil || d.Open() != 0 {
+		t.Errorf("Expected no open task, got %+v", d.Next())
+	}
+	if err := d.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	content, _ := os.ReadFile(path)
+	want := `- [b] One <!-- id:1 -->
+  failed: tests fail
+  blocked: gave up after 2 attempts
+- [x] Two <!-- id:2 -->
+- [-] Three <!-- id:3 -->
+  skipped: not needed
+`
+	if string(content) != want {
+		t.Errorf("Unexpected TASKS.md:\n%s", content)
+	}
+	d, _ = Load(path)
+	one := d.Meta(1)
+	if one.Attempts != 2 || one.Reason != "gave up after 2 attempts" || one.LastError != "tests fail" {
+		t.Errorf("Unexpected meta for task 1: %+v", one)
+	}
+	if !one.StartedAt.Before(one.FinishedAt) || one.FinishedAt != one.UpdatedAt {
+		t.Errorf("Unexpected timestamps for task 1: %+v", one)
+	}
+	if _, err := os.Stat(MetaPath(path)); err != nil || !strings.HasSuffix(MetaPath(path), "TASKS.meta.json") {
+		t.Errorf("Expected the meta file next to TASKS.md: %v", err)
+	}
+	if !strings.Contains(d.Format(), "- #1 [blocked, 2 attempts] One") {
+		t.Errorf("Expected the attempts in the listing, got:\n%s", d.Format())
+	}
+}
+
+func TestDepends(t *testing.T) {
+	d := Parse(`- [ ] Schema <!-- id:1 -->
+  independent
+- [ ] Handlers <!-- id:2 -->
+  depends: 1, #4
+- [ ] Docs <!-- id:3 -->
+  depends: 4
+- [-] Logo <!-- id:4 -->
+- [ ] Release <!-- id:5 -->
+`)
+	if deps := d.Find(2).Depends(); len(deps) != 2 || deps[0] != 1 || deps[1] != 4 {
+		t.Errorf("Unexpected dependencies %v", deps)
+	}
+	ids := func(list []*Task) []int {
+		var ids []int
+		for _, task := range list {
+			ids = append(ids, task.ID)
+		}
+		return ids
+	}
+	if got := ids(d.Parallel()); len(got) != 2 || got[0] != 1 || got[1] != 3 {
+		t.Errorf("Expected tasks 1 and 3 to run in parallel, got %v", got)
+	}
+	d.Start(1)
+	if next := d.Next(); next.ID != 1 {
+		t.Errorf("Expected task 1 to be next, got %d", next.ID)
+	}
+	d.SetState(1, Done)
+	if next := d.Next(); next.ID != 2 {
+		t.Errorf("Expected task 2 to be ready once task 1 is done, got %d", next.ID)
+	}
+	if got := ids(d.Parallel()); len(got) != 2 || got[0] != 2 || got[1] != 3 {
+		t.Errorf("Expected tasks 2 and 3 to run in parallel, got %v", got)
+	}
+}
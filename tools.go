@@ -1,8 +1,10 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"os"
 
 	"github.com/sashabaranov/go-openai"
 	"github.com/sashabaranov/go-openai/jsonschema"
@@ -233,10 +235,325 @@ func GetTools() []openai.Tool {
 				},
 			},
 		},
+		{
+			Type: openai.ToolTypeFunction,
+			Function: &openai.FunctionDefinition{
+				Name:        "git_status",
+				Description: "Show the git status of the working directory",
+			},
+		},
+		{
+			Type: openai.ToolTypeFunction,
+			Function: &openai.FunctionDefinition{
+				Name:        "git_diff",
+				Description: "Show the uncommitted changes of the working directory",
+				Parameters: jsonschema.Definition{
+					Type: jsonschema.Object,
+					Properties: map[string]jsonschema.Definition{
+						"staged": {
+							Type:        jsonschema.Boolean,
+							Description: "Show the staged changes instead of the unstaged ones (optional)",
+						},
+						"path": {
+							Type:        jsonschema.String,
+							Description: "Only show the changes of this file or directory, relative to the working directory (optional)",
+						},
+					},
+				},
+			},
+		},
+		{
+			Type: openai.ToolTypeFunction,
+			Function: &openai.FunctionDefinition{
+				Name:        "git_log",
+				Description: "Show the last commits",
+				Parameters: jsonschema.Definition{
+					Type: jsonschema.Object,
+					Properties: map[string]jsonschema.Definition{
+						"count": {
+							Type:        jsonschema.Integer,
+							Description: "The number of commits to show, 10 by default",
+						},
+						"path": {
+							Type:        jsonschema.String,
+							Description: "Only show the commits changing this file or directory (optional)",
+						},
+					},
+				},
+			},
+		},
+		{
+			Type: openai.ToolTypeFunction,
+			Function: &openai.FunctionDefinition{
+				Name:        "list_tasks",
+				Description: "List the tasks of TASKS.md with their ids, states and notes",
+			},
+		},
+		{
+			Type: openai.ToolTypeFunction,
+			Function: &openai.FunctionDefinition{
+				Name:        "add_task",
+				Description: "Add a task to TASKS.md",
+				Parameters: jsonschema.Definition{
+					Type: jsonschema.Object,
+					Properties: map[string]jsonschema.Definition{
+						"title": {
+							Type:        jsonschema.String,
+							Description: "A short description of the task",
+						},
+						"parent_id": {
+							Type:        jsonschema.Integer,
+							Description: "The id of the task to add a subtask to (optional)",
+						},
+						"notes": {
+							Type:        jsonschema.Array,
+							Description: "Details of the task, one per line (optional). Acceptance checks are notes like \"accept: go test ./...\", \"accept: exists <path>\", \"accept: grep <regexp> <path>\" or \"accept: !grep <regexp> <path>\"",
+							Items: &jsonschema.Definition{
+								Type: jsonschema.String,
+							},
+						},
+					},
+					Required: []string{"title"},
+				},
+			},
+		},
+		{
+			Type: openai.ToolTypeFunction,
+			Function: &openai.FunctionDefinition{
+				Name:        "submit_questions",
+				Description: "Submit the questions about INPUT.md to ask before planning, an empty list when it is clear",
+				Parameters: jsonschema.Definition{
+					Type: jsonschema.Object,
+					Properties: map[string]jsonschema.Definition{
+						"questions": {
+							Type:        jsonschema.Array,
+							Description: "The questions",
+							Items: &jsonschema.Definition{
+								Type: jsonschema.Object,
+								Properties: map[string]jsonschema.Definition{
+									"question": {
+										Type:        jsonschema.String,
+										Description: "The question",
+									},
+									"context": {
+										Type:        jsonschema.String,
+										Description: "Why it matters, what in INPUT.md or the project raised it (optional)",
+									},
+									"assumption": {
+										Type:        jsonschema.String,
+										Description: "What you would do without an answer",
+									},
+								},
+								Required: []string{"question", "assumption"},
+							},
+						},
+					},
+					Required: []string{"questions"},
+				},
+			},
+		},
+		{
+			Type: openai.ToolTypeFunction,
+			Function: &openai.FunctionDefinition{
+				Name:        "submit_plan",
+				Description: "Submit the plan: the tasks of INPUT.md with their dependencies. The plan is validated and its tasks are added to TASKS.md",
+				Parameters: jsonschema.Definition{
+					Type: jsonschema.Object,
+					Properties: map[string]jsonschema.Definition{
+						"tasks": {
+							Type:        jsonschema.Array,
+							Description: "The tasks, in any order",
+							Items: &jsonschema.Definition{
+								Type: jsonschema.Object,
+								Properties: map[string]jsonschema.Definition{
+									"id": {
+										Type:        jsonschema.Integer,
+										Description: "A positive number identifying the task in the plan",
+									},
+									"title": {
+										Type:        jsonschema.String,
+										Description: "A short description of the task",
+									},
+									"depends_on": {
+										Type:        jsonschema.Array,
+										Description: "The ids of the tasks that must be done first",
+										Items:       &jsonschema.Definition{Type: jsonschema.Integer},
+									},
+									"size": {
+										Type:        jsonschema.String,
+										Description: "The estimated size: s for a few lines, m for a file, l for several files",
+										Enum:        planSizes,
+									},
+									"files": {
+										Type:        jsonschema.Array,
+										Description: "The files the task will create or change",
+										Items:       &jsonschema.Definition{Type: jsonschema.String},
+									},
+									"accept": {
+										Type:        jsonschema.Array,
+										Description: "Acceptance checks: \"<shell command>\", \"exists <path>\", \"grep <regexp> <path>\" or \"!grep <regexp> <path>\"",
+										Items:       &jsonschema.Definition{Type: jsonschema.String},
+									},
+									"notes": {
+										Type:        jsonschema.Array,
+										Description: "Details of the task (optional)",
+										Items:       &jsonschema.Definition{Type: jsonschema.String},
+									},
+								},
+								Required: []string{"id", "title", "size"},
+							},
+						},
+					},
+					Required: []string{"tasks"},
+				},
+			},
+		},
+		{
+			Type: openai.ToolTypeFunction,
+			Function: &openai.FunctionDefinition{
+				Name:        "start_task",
+				Description: "Mark a task as in progress",
+				Parameters: jsonschema.Definition{
+					Type: jsonschema.Object,
+					Properties: map[string]jsonschema.Definition{
+						"id": {
+							Type:        jsonschema.Integer,
+							Description: "The id of the task",
+						},
+					},
+					Required: []string{"id"},
+				},
+			},
+		},
+		{
+			Type: openai.ToolTypeFunction,
+			Function: &openai.FunctionDefinition{
+				Name:        "complete_task",
+				Description: "Mark a task as done. Its acceptance checks are run first and the task stays open when one fails",
+				Parameters: jsonschema.Definition{
+					Type: jsonschema.Object,
+					Properties: map[string]jsonschema.Definition{
+						"id": {
+							Type:        jsonschema.Integer,
+							Description: "The id of the task",
+						},
+					},
+					Required: []string{"id"},
+				},
+			},
+		},
+		{
+			Type: openai.ToolTypeFunction,
+			Function: &openai.FunctionDefinition{
+				Name:        "skip_task",
+				Description: "Mark a task as skipped when it is not needed anymore",
+				Parameters: jsonschema.Definition{
+					Type: jsonschema.Object,
+					Properties: map[string]jsonschema.Definition{
+						"id": {
+							Type:        jsonschema.Integer,
+							Description: "The id of the task",
+						},
+						"reason": {
+							Type:        jsonschema.String,
+							Description: "Why the task is not needed",
+						},
+					},
+					Required: []string{"id", "reason"},
+				},
+			},
+		},
+		{
+			Type: openai.ToolTypeFunction,
+			Function: &openai.FunctionDefinition{
+				Name:        "fail_task",
+				Description: "Mark a task as failed when it cannot be done",
+				Parameters: jsonschema.Definition{
+					Type: jsonschema.Object,
+					Properties: map[string]jsonschema.Definition{
+						"id": {
+							Type:        jsonschema.Integer,
+							Description: "The id of the task",
+						},
+						"reason": {
+							Type:        jsonschema.String,
+							Description: "Why the task cannot be done",
+						},
+					},
+					Required: []string{"id", "reason"},
+				},
+			},
+		},
+	}
+}
+
+// readOnlyTools are the tools that do not modify the working directory and can run
+// concurrently. Every other tool is treated as a write.
+var readOnlyTools = map[string]bool{
+	"visit_web_page":  true,
+	"web_page_search": true,
+	"list_directory":  true,
+	"read_file":       true,
+	"search_text":     true,
+	"fetch_wiki_docs": true,
+	"read_code":       true,
+	"list_tasks":      true,
+	"git_status":      true,
+	"git_diff":        true,
+	"git_log":         true,
+}
+
+func IsReadOnlyTool(name string) bool {
+	return readOnlyTools[name]
+}
+
+func isWebTool(name string) bool {
+	return name == "visit_web_page" || name == "web_page_search"
+}
+
+// readsTree reports whether a read-only tool reads the whole tree or a directory: it has no
+// path, or its path is a directory.
+func readsTree(name, key string) bool {
+	switch name {
+	case "list_directory", "search_text", "fetch_wiki_docs", "git_status", "git_diff", "git_log":
+		return true
+	}
+	if key == "" {
+		return true
+	}
+	info, err := os.Stat(key)
+	return err == nil && info.IsDir()
+}
+
+func isTaskTool(name string) bool {
+	switch name {
+	case "list_tasks", "add_task", "submit_plan", "start_task", "complete_task", "fail_task", "skip_task":
+		return true
+	}
+	return false
+}
+
+// lintsPackage reports whether a write tool lints the Go package of its path, which runs
+// go mod tidy and go fmt and rewrites go.mod, go.sum and the Go files next to it.
+func lintsPackage(name string) bool {
+	return name == "write_file" || name == "lint_file"
+}
+
+// toolPathKey returns the absolute path a tool call works on, or "" for tools without a path.
+func toolPathKey(toolCall openai.ToolCall) string {
+	if isTaskTool(toolCall.Function.Name) {
+		return TasksPath()
 	}
+	var arguments struct {
+		Path string `json:"path"`
+	}
+	if err := json.Unmarshal([]byte(toolCall.Function.Arguments), &arguments); err != nil || arguments.Path == "" {
+		return ""
+	}
+	return Path(arguments.Path)
 }
 
-func ToolCall(toolCall openai.ToolCall) string {
+func ToolCall(ctx context.Context, toolCall openai.ToolCall) string {
 	switch toolCall.Function.Name {
 	case "visit_web_page":
 		var arguments struct {
@@ -248,7 +565,7 @@ func ToolCall(toolCall openai.ToolCall) string {
 		if err != nil {
 			return fmt.Sprintf("Error unmarshalling url: %s", err)
 		}
-		return WebSource(arguments.URL, arguments.Headers, arguments.Cookies)
+		return WebSource(ctx, arguments.URL, arguments.Headers, arguments.Cookies)
 	case "web_page_search":
 		var arguments struct {
 			Query string `json:"query"`
@@ -257,7 +574,7 @@ func ToolCall(toolCall openai.ToolCall) string {
 		if err != nil {
 			return fmt.Sprintf("Error unmarshalling query: %s", err)
 		}
-		return WebSearch(arguments.Query)
+		return WebSearch(ctx, arguments.Query)
 	case "list_directory":
 		var arguments struct {
 			Path  string `json:"path"`
@@ -288,7 +605,7 @@ func ToolCall(toolCall openai.ToolCall) string {
 		if err != nil {
 			return fmt.Sprintf("Error unmarshalling path: %s", err)
 		}
-		return WriteFile(arguments.Path, arguments.Content)
+		return WriteFile(ctx, arguments.Path, arguments.Content)
 	case "make_directory":
 		var arguments struct {
 			Path string `json:"path"`
@@ -308,7 +625,7 @@ func ToolCall(toolCall openai.ToolCall) string {
 		if err != nil {
 			return fmt.Sprintf("Error unmarshalling path: %s", err)
 		}
-		return Lint(arguments.Path)
+		return Lint(ctx, arguments.Path)
 	case "search_text":
 		var arguments struct {
 			Query string `json:"query"`
@@ -317,7 +634,7 @@ func ToolCall(toolCall openai.ToolCall) string {
 		if err != nil {
 			return fmt.Sprintf("Error unmarshalling query: %s", err)
 		}
-		return SearchText(arguments.Query)
+		return SearchText(ctx, arguments.Query)
 	case "read_code":
 		var arguments struct {
 			Path      string   `json:"path"`
@@ -339,6 +656,77 @@ func ToolCall(toolCall openai.ToolCall) string {
 			return fmt.Sprintf("Error unmarshalling arguments: %s", err)
 		}
 		return AddOrEditFunction(arguments.Path, arguments.FunctionName, arguments.FunctionBody)
+	case "git_status":
+		return GitStatus(ctx)
+	case "git_diff":
+		var arguments struct {
+			Staged bool   `json:"staged"`
+			Path   string `json:"path"`
+		}
+		if toolCall.Function.Arguments != "" {
+			if err := json.Unmarshal([]byte(toolCall.Function.Arguments), &arguments); err != nil {
+				return fmt.Sprintf("Error unmarshalling arguments: %s", err)
+			}
+		}
+		return GitDiff(ctx, arguments.Staged, arguments.Path)
+	case "git_log":
+		var arguments struct {
+			Count int    `json:"count"`
+			Path  string `json:"path"`
+		}
+		if toolCall.Function.Arguments != "" {
+			if err := json.Unmarshal([]byte(toolCall.Function.Arguments), &arguments); err != nil {
+				return fmt.Sprintf("Error unmarshalling arguments: %s", err)
+			}
+		}
+		return GitLog(ctx, arguments.Count, arguments.Path)
+	case "list_tasks":
+		return ListTasks()
+	case "add_task":
+		var arguments struct {
+			Title    string   `json:"title"`
+			ParentID int      `json:"parent_id"`
+			Notes    []string `json:"notes"`
+		}
+		err := json.Unmarshal([]byte(toolCall.Function.Arguments), &arguments)
+		if err != nil {
+			return fmt.Sprintf("Error unmarshalling arguments: %s", err)
+		}
+		return AddTask(arguments.Title, arguments.ParentID, arguments.Notes)
+	case "submit_questions":
+		var arguments struct {
+			Questions []Question `json:"questions"`
+		}
+		err := json.Unmarshal([]byte(toolCall.Function.Arguments), &arguments)
+		if err != nil {
+			return fmt.Sprintf("Error unmarshalling arguments: %s", err)
+		}
+		return SubmitQuestions(arguments.Questions)
+	case "submit_plan":
+		var plan Plan
+		err := json.Unmarshal([]byte(toolCall.Function.Arguments), &plan)
+		if err != nil {
+			return fmt.Sprintf("Error unmarshalling arguments: %s", err)
+		}
+		return SubmitPlan(plan)
+	case "start_task", "complete_task", "fail_task", "skip_task":
+		var arguments struct {
+			ID     int    `json:"id"`
+			Reason string `json:"reason"`
+		}
+		err := json.Unmarshal([]byte(toolCall.Function.Arguments), &arguments)
+		if err != nil {
+			return fmt.Sprintf("Error unmarshalling arguments: %s", err)
+		}
+		switch toolCall.Function.Name {
+		case "start_task":
+			return StartTask(arguments.ID)
+		case "complete_task":
+			return CompleteTask(ctx, arguments.ID)
+		case "skip_task":
+			return SkipTask(arguments.ID, arguments.Reason)
+		}
+		return FailTask(arguments.ID, arguments.Reason)
 	}
 	return fmt.Sprintf("Unknown tool call: %s", toolCall.Function.Name)
 }
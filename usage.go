@@ -0,0 +1,262 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// Price is the cost of a model in dollars per million tokens.
+type Price struct {
+	Prompt     float64 `json:"prompt"`
+	Completion float64 `json:"completion"`
+}
+
+// Prices of the models we know about. Config.Prices adds or overrides entries.
+var prices = map[string]Price{
+	"openai/gpt-4.1":                  {Prompt: 2, Completion: 8},
+	"openai/gpt-4.1-mini":             {Prompt: 0.4, Completion: 1.6},
+	"openai/gpt-4o":                   {Prompt: 2.5, Completion: 10},
+	"openai/gpt-4o-mini":              {Prompt: 0.15, Completion: 0.6},
+	"google/gemini-2.5-flash-preview": {Prompt: 0.15, Completion: 0.6},
+	"google/gemini-2.5-pro-preview":   {Prompt: 1.25, Completion: 10},
+	"anthropic/claude-3.7-sonnet":     {Prompt: 3, Completion: 15},
+	"claude-3-7-sonnet-latest":        {Prompt: 3, Completion: 15},
+	"claude-sonnet-4-0":               {Prompt: 3, Completion: 15},
+}
+
+// Budget holds the hard limits of a run. Zero means no limit.
+type Budget struct {
+	MaxTokens  int     `json:"max_tokens,omitempty"`
+	MaxDollars float64 `json:"max_dollars,omitempty"`
+	MaxCalls   int     `json:"max_calls,omitempty"`
+}
+
+// Split divides the limits of the budget between n runs, keeping at least one call and
+// one token for each.
+func (b Budget) Split(n int) Budget {
+	if n <= 1 {
+		return b
+	}
+	if b.MaxCalls > 0 {
+		b.MaxCalls = max(b.MaxCalls/n, 1)
+	}
+	if b.MaxTokens > 0 {
+		b.MaxTokens = max(b.MaxTokens/n, 1)
+	}
+	b.MaxDollars /= float64(n)
+	return b
+}
+
+// BudgetError is returned instead of calling the model once the budget is spent.
+type BudgetError struct {
+	Limit string
+}
+
+func (e *BudgetError) Error() string {
+	return fmt.Sprintf("budget exceeded: %s", e.Limit)
+}
+
+type Usage struct {
+	Calls            int     `json:"calls"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	Cost             float64 `json:"cost"`
+}
+
+func (u *Usage) Add(other Usage) {
+	u.Calls += other.Calls
+	u.PromptTokens += other.PromptTokens
+	u.CompletionTokens += other.CompletionTokens
+	u.Cost += other.Cost
+}
+
+func (u Usage) TotalTokens() int {
+	return u.PromptTokens + u.CompletionTokens
+}
+
+type CallUsage struct {
+	Time  time.Time `json:"time"`
+	Model string    `json:"model"`
+	Task  string    `json:"task"`
+	Usage Usage     `json:"usage"`
+}
+
+// UsageTracker accounts the tokens and cost of every model call, per task and per run.
+type UsageTracker struct {
+	mu sync.Mutex
+
+	// Task is the label the next calls are accounted to.
+	Task string `json:"task"`
+
+	Run    Usage            `json:"run"`
+	Tasks  map[string]Usage `json:"tasks"`
+	Models map[string]Usage `json:"models"`
+	Calls  []CallUsage      `json:"calls"`
+	// UnknownPrices lists the models used that have no price, their cost is counted as 0.
+	UnknownPrices []string `json:"unknown_prices,omitempty"`
+}
+
+var usage = &UsageTracker{}
+
+func priceOf(model string) (Price, bool) {
+	if price, ok := config.Prices[model]; ok {
+		return price, true
+	}
+	price, ok := prices[model]
+	return price, ok
+}
+
+func (t *UsageTracker) SetTask(task string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.Task = task
+}
+
+// Record accounts the usage returned by one model call.
+func (t *UsageTracker) Record(model string, u openai.Usage) Usage {
+	price, known := priceOf(model)
+	call := Usage{
+		Calls:            1,
+		PromptTokens:     u.PromptTokens,
+		CompletionTokens: u.CompletionTokens,
+		Cost:             (float64(u.PromptTokens)*price.Prompt + float64(u.CompletionTokens)*price.Completion) / 1e6,
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.Tasks == nil {
+		t.Tasks = map[string]Usage{}
+	}
+	if t.Models == nil {
+		t.Models = map[string]Usage{}
+	}
+	t.Run.Add(call)
+	taskUsage := t.Tasks[t.Task]
+	taskUsage.Add(call)
+	t.Tasks[t.Task] = taskUsage
+	modelUsage := t.Models[model]
+	modelUsage.Add(call)
+	t.Models[model] = modelUsage
+	t.Calls = append(t.Calls, CallUsage{Time: time.Now(), Model: model, Task: t.Task, Usage: call})
+	if !known && !contains(t.UnknownPrices, model) {
+		t.UnknownPrices = append(t.UnknownPrices, model)
+	}
+	return call
+}
+
+// Merge accounts the usage of another run, like a parallel worker, to task.
+func (t *UsageTracker) Merge(other *UsageTracker, task string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.Tasks == nil {
+		t.Tasks = map[string]Usage{}
+	}
+	if t.Models == nil {
+		t.Models = map[string]Usage{}
+	}
+	t.Run.Add(other.Run)
+	taskUsage := t.Tasks[task]
+	taskUsage.Add(other.Run)
+	t.Tasks[task] = taskUsage
+	for model, u := range other.Models {
+		modelUsage := t.Models[model]
+		modelUsage.Add(u)
+		t.Models[model] = modelUsage
+	}
+	for _, call := range other.Calls {
+		call.Task = task
+		t.Calls = append(t.Calls, call)
+	}
+	for _, model := range other.UnknownPrices {
+		if !contains(t.UnknownPrices, model) {
+			t.UnknownPrices = append(t.UnknownPrices, model)
+		}
+	}
+}
+
+// Remaining returns what is left of budget.
+func (t *UsageTracker) Remaining(budget Budget) Budget {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if budget.MaxCalls > 0 {
+		budget.MaxCalls -= t.Run.Calls
+	}
+	if budget.MaxTokens > 0 {
+		budget.MaxTokens -= t.Run.TotalTokens()
+	}
+	if budget.MaxDollars > 0 {
+		budget.MaxDollars -= t.Run.Cost
+	}
+	return budget
+}
+
+// CheckBudget returns a *BudgetError when the run has reached one of the limits.
+func (t *UsageTracker) CheckBudget(budget Budget) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch {
+	case budget.MaxCalls > 0 && t.Run.Calls >= budget.MaxCalls:
+		return &BudgetError{Limit: fmt.Sprintf("%d model calls", budget.MaxCalls)}
+	case budget.MaxTokens > 0 && t.Run.TotalTokens() >= budget.MaxTokens:
+		return &BudgetError{Limit: fmt.Sprintf("%d tokens", budget.MaxTokens)}
+	case budget.MaxDollars > 0 && t.Run.Cost >= budget.MaxDollars:
+		return &BudgetError{Limit: fmt.Sprintf("$%.2f", budget.MaxDollars)}
+	}
+	return nil
+}
+
+// Report renders the usage as markdown.
+func (t *UsageTracker) Report() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var report strings.Builder
+	report.WriteString("# Usage\n\n")
+	report.WriteString(fmt.Sprintf("- Model calls: %d\n", t.Run.Calls))
+	report.WriteString(fmt.Sprintf("- Tokens: %d (%d prompt, %d completion)\n", t.Run.TotalTokens(), t.Run.PromptTokens, t.Run.CompletionTokens))
+	report.WriteString(fmt.Sprintf("- Cost: $%.4f\n", t.Run.Cost))
+	if len(t.UnknownPrices) > 0 {
+		report.WriteString(fmt.Sprintf("- Models without a price (counted as $0): %s\n", strings.Join(t.UnknownPrices, ", ")))
+	}
+
+	table := func(title string, rows map[string]Usage) {
+		report.WriteString(fmt.Sprintf("\n## %s\n\n| | Calls | Prompt tokens | Completion tokens | Cost |\n|---|---|---|---|---|\n", title))
+		var keys []string
+		for key := range rows {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			u := rows[key]
+			report.WriteString(fmt.Sprintf("| %s | %d | %d | %d | $%.4f |\n", key, u.Calls, u.PromptTokens, u.CompletionTokens, u.Cost))
+		}
+	}
+	table("Per task", t.Tasks)
+	table("Per model", t.Models)
+	return report.String()
+}
+
+// WriteUsageReport writes the usage of the run to .dev/usage.md.
+func WriteUsageReport() error {
+	path := filepath.Join(workingDirectory, ".dev", "usage.md")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(usage.Report()), 0644)
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"net/http/cookiejar"
@@ -9,8 +10,8 @@ import (
 	"github.com/gocolly/colly/v2"
 )
 
-func WebSource(url string, headers map[string]string, cookies map[string]string) string {
-	c := colly.NewCollector()
+func WebSource(ctx context.Context, url string, headers map[string]string, cookies map[string]string) string {
+	c := colly.NewCollector(colly.StdlibContext(ctx))
 	source := ""
 
 	if len(headers) > 0 {
@@ -59,8 +60,8 @@ type WebSearchResult struct {
 	Snippet  string
 }
 
-func WebSearch(query string) string {
-	c := colly.NewCollector()
+func WebSearch(ctx context.Context, query string) string {
+	c := colly.NewCollector(colly.StdlibContext(ctx))
 	results := []WebSearchResult{}
 
 	c.OnHTML(".result", func(e *colly.HTMLElement) {
@@ -1,9 +1,13 @@
 package main
 
-import "github.com/sashabaranov/go-openai"
+import (
+	"context"
 
-func GenWiki() {
-	handleChatCompletion(openai.ChatCompletionMessage{
+	"github.com/sashabaranov/go-openai"
+)
+
+func GenWiki(ctx context.Context) error {
+	_, err := converse(ctx, config.Models.Executor, openai.ChatCompletionMessage{
 		Role: openai.ChatMessageRoleUser,
 		Content: `
 			1. Analyze the code in the current directory and generate high level documentation for the code.
@@ -14,4 +18,5 @@ func GenWiki() {
 			If you cannot continue, create a new task in the TASKS.md file.
 		`,
 	})
+	return err
 }